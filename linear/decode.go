@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Response formats of /db/query
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// CompressGzip is the compression of a response format, e.g. csv+gzip.
+const CompressGzip = "gzip"
+
+// ResponseFormat is the output format of /db/query and its compression.
+type ResponseFormat struct {
+	Format   string
+	Compress string // "" or gzip
+}
+
+func (rf ResponseFormat) String() string {
+	if rf.Compress == "" {
+		return rf.Format
+	}
+	return rf.Format + "+" + rf.Compress
+}
+
+// ParseFormat parses the -format flag, format[+gzip].
+func ParseFormat(str string) (ResponseFormat, error) {
+	return parseFormat("-format", str)
+}
+
+// ParseFormats parses the -formats flag, a comma separated list of format[+gzip],
+// e.g. "json,json+gzip,csv,ndjson".
+func ParseFormats(str string) ([]ResponseFormat, error) {
+	ret := []ResponseFormat{}
+	for _, item := range strings.Split(str, ",") {
+		rf, err := parseFormat("-formats", strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(ret, rf) {
+			return nil, fmt.Errorf("duplicated -formats %q", rf)
+		}
+		ret = append(ret, rf)
+	}
+	return ret, nil
+}
+
+func parseFormat(flagName string, str string) (ResponseFormat, error) {
+	format, compress, _ := strings.Cut(str, "+")
+	switch format {
+	case FormatJSON, FormatCSV, FormatNDJSON:
+		if compress == "" || compress == CompressGzip {
+			return ResponseFormat{Format: format, Compress: compress}, nil
+		}
+	}
+	return ResponseFormat{}, fmt.Errorf("invalid %s %q, use json, csv or ndjson, +gzip for the compressed, e.g. csv+gzip", flagName, str)
+}
+
+// meteredReader counts the bytes of the response body
+// and the time spent waiting for them, which is not the decode time.
+type meteredReader struct {
+	r     io.Reader
+	bytes int64
+	wait  time.Duration
+}
+
+func (mr *meteredReader) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := mr.r.Read(b)
+	mr.wait += time.Since(start)
+	mr.bytes += int64(n)
+	return n, err
+}
+
+// decompress returns the reader of the body of the compression.
+// The body is read as is if the server did not compress it.
+func decompress(compress string, r io.Reader) (io.Reader, error) {
+	if compress != CompressGzip {
+		return r, nil
+	}
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// ndjsonMaxLine bounds the memory of a row of NDJSON.
+const ndjsonMaxLine = 16 << 20
+
+// responseMeta is the status of a JSON response, the other formats have no status.
+type responseMeta struct {
+	success bool
+	reason  string
+	elapse  string
+}
+
+// decodeRows decodes the response body of the format row by row, only a row is
+// held at a time. onRow is called with every row, a JSON array or object,
+// or a JSON array of the fields of a CSV record.
+func decodeRows(format string, r io.Reader, onRow func(row []byte)) (responseMeta, error) {
+	switch format {
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return responseMeta{success: true}, nil
+			} else if err != nil {
+				return responseMeta{}, err
+			}
+			row, _ := json.Marshal(rec)
+			onRow(row)
+		}
+	case FormatNDJSON:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), ndjsonMaxLine)
+		for sc.Scan() {
+			if line := sc.Bytes(); len(strings.TrimSpace(string(line))) > 0 {
+				onRow(line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return responseMeta{}, err
+		}
+		return responseMeta{success: true}, nil
+	}
+	return decodeJSON(r, onRow)
+}
+
+// decodeJSON walks {"data":{"columns":..., "rows":[...]}, "success":..., "reason":..., "elapse":...}
+// by tokens, so that the rows are decoded one by one.
+func decodeJSON(r io.Reader, onRow func(row []byte)) (responseMeta, error) {
+	meta := responseMeta{}
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return meta, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return meta, err
+		}
+		switch key {
+		case "data":
+			if err := decodeData(dec, onRow); err != nil {
+				return meta, err
+			}
+		case "success":
+			err = dec.Decode(&meta.success)
+		case "reason":
+			err = dec.Decode(&meta.reason)
+		case "elapse":
+			err = dec.Decode(&meta.elapse)
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return meta, err
+		}
+	}
+	return meta, expectDelim(dec, '}')
+}
+
+func decodeData(dec *json.Decoder, onRow func(row []byte)) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key != "rows" {
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		var row json.RawMessage
+		for dec.More() {
+			if err := dec.Decode(&row); err != nil {
+				return err
+			}
+			onRow(row)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var v json.RawMessage
+	return dec.Decode(&v)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InListGen generates the IN-list and time-bounded query family,
+//
+//	select count(*) from (select * from <table> where meta1 in (<meta1 list>)
+//	and time < to_date('<before>') and meta2 in (<meta2 list>))
+//
+// The n-th query takes Meta1 consecutive meta1 values from n*Meta1+1, and
+// Meta2Groups groups of Meta2GroupSize consecutive meta2 values, the groups start
+// Meta2Stride apart from n*Meta2Groups*Meta2Stride+1. The defaults generate the "multi" scenario.
+type InListGen struct {
+	Queries        int
+	Table          string
+	Meta1          int
+	Meta2Groups    int
+	Meta2GroupSize int
+	Meta2Stride    int
+	Before         string // empty means no time bound
+}
+
+func DefaultInListGen() InListGen {
+	return InListGen{
+		Queries:        40,
+		Table:          "tag",
+		Meta1:          5,
+		Meta2Groups:    5,
+		Meta2GroupSize: 5,
+		Meta2Stride:    50,
+		Before:         "2025-01-01 01:02:00",
+	}
+}
+
+// ParseInListGen parses the -gen flag, comma separated key=value overrides of the defaults,
+// e.g. "queries=100,meta1=10,before=2025-01-01 00:00:00".
+func ParseInListGen(str string) (InListGen, error) {
+	g := DefaultInListGen()
+	for _, kv := range strings.Split(str, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return g, fmt.Errorf("invalid -gen %q, use key=value", kv)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "table" {
+			g.Table = v
+			continue
+		}
+		if k == "before" {
+			g.Before = v
+			continue
+		}
+		var n *int
+		switch k {
+		case "queries":
+			n = &g.Queries
+		case "meta1":
+			n = &g.Meta1
+		case "meta2_groups":
+			n = &g.Meta2Groups
+		case "meta2_group_size":
+			n = &g.Meta2GroupSize
+		case "meta2_stride":
+			n = &g.Meta2Stride
+		default:
+			return g, fmt.Errorf("invalid -gen key %q, use queries, table, meta1, meta2_groups, meta2_group_size, meta2_stride or before", k)
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i <= 0 {
+			return g, fmt.Errorf("invalid -gen %s=%q, use a positive integer", k, v)
+		}
+		*n = i
+	}
+	if g.Meta2GroupSize > g.Meta2Stride {
+		return g, fmt.Errorf("invalid -gen, meta2_group_size %d is larger than meta2_stride %d", g.Meta2GroupSize, g.Meta2Stride)
+	}
+	return g, nil
+}
+
+func (g InListGen) Generate() []string {
+	ret := make([]string, 0, g.Queries)
+	for n := range g.Queries {
+		meta1 := make([]string, 0, g.Meta1)
+		for i := range g.Meta1 {
+			meta1 = append(meta1, fmt.Sprintf("'m1-%04d'", n*g.Meta1+i+1))
+		}
+		meta2 := make([]string, 0, g.Meta2Groups*g.Meta2GroupSize)
+		for grp := range g.Meta2Groups {
+			from := (n*g.Meta2Groups+grp)*g.Meta2Stride + 1
+			for i := range g.Meta2GroupSize {
+				meta2 = append(meta2, fmt.Sprintf("'m2-%04d'", from+i))
+			}
+		}
+		timeBound := ""
+		if g.Before != "" {
+			timeBound = fmt.Sprintf("and time < to_date('%s') ", g.Before)
+		}
+		ret = append(ret, fmt.Sprintf("select count(*) from (select * from %s where meta1 in (\n%s) \n%sand meta2 in (\n%s))",
+			g.Table, strings.Join(meta1, ", \n"), timeBound, strings.Join(meta2, ", \n")))
+	}
+	return ret
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GoldenEntry is the expected result of a query.
+// A query is Unstable if it returned different results while recording,
+// e.g. a LIMIT without ORDER BY, it is not verified.
+type GoldenEntry struct {
+	Rows     int    `json:"rows"`
+	Hash     string `json:"hash"`
+	Unstable bool   `json:"unstable,omitempty"`
+}
+
+// goldenFile is the JSON of a golden file.
+type goldenFile struct {
+	Format  string                  `json:"format"` // response format of the recorded queries
+	Queries map[string]*GoldenEntry `json:"queries"`
+}
+
+// Golden records the results of the queries with -record,
+// or checks them against the recorded results with -verify.
+type Golden struct {
+	mu       sync.Mutex
+	format   string
+	entries  map[string]*GoldenEntry // query -> expected result
+	record   bool
+	checked  int64
+	unknown  int64 // queries not in the golden file
+	mismatch map[string]*goldenMismatch
+}
+
+type goldenMismatch struct {
+	count    int64
+	expected GoldenEntry
+	last     GoldenEntry
+}
+
+// NewGoldenRecorder records the results of the queries in the response format.
+func NewGoldenRecorder(format string) *Golden {
+	return &Golden{format: format, entries: map[string]*GoldenEntry{}, record: true}
+}
+
+// LoadGolden loads the golden file of -verify.
+func LoadGolden(path string) (*Golden, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := goldenFile{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.Format == "" || f.Queries == nil {
+		return nil, fmt.Errorf("%s: no format or queries, record it again with -record", path)
+	}
+	return &Golden{format: f.Format, entries: f.Queries, mismatch: map[string]*goldenMismatch{}}, nil
+}
+
+// Format returns the response format of the recorded results.
+func (g *Golden) Format() string {
+	return g.format
+}
+
+// RowHasher hashes the rows of a response as they are decoded.
+// The hash is the sum of the SHA-256 of every compacted row modulo 2^256,
+// so that the order of the rows does not matter and no row is kept.
+type RowHasher struct {
+	rows int
+	sum  [4]uint64
+	buf  bytes.Buffer
+}
+
+func (h *RowHasher) Add(row []byte) {
+	h.rows++
+	h.buf.Reset()
+	if err := json.Compact(&h.buf, row); err != nil {
+		h.buf.Reset()
+		h.buf.Write(row)
+	}
+	digest := sha256.Sum256(h.buf.Bytes())
+	var carry uint64
+	for i := 3; i >= 0; i-- {
+		h.sum[i], carry = bits.Add64(h.sum[i], binary.BigEndian.Uint64(digest[i*8:]), carry)
+	}
+}
+
+func (h *RowHasher) Entry() GoldenEntry {
+	b := make([]byte, 32)
+	for i, v := range h.sum {
+		binary.BigEndian.PutUint64(b[i*8:], v)
+	}
+	return GoldenEntry{Rows: h.rows, Hash: hex.EncodeToString(b)}
+}
+
+// Add records or checks the result of the query.
+func (g *Golden) Add(query string, got GoldenEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expected, ok := g.entries[query]
+	if g.record {
+		if !ok {
+			g.entries[query] = &got
+		} else if expected.Hash != got.Hash {
+			expected.Unstable = true
+		}
+		return
+	}
+	if !ok {
+		g.unknown++
+		return
+	}
+	if expected.Unstable {
+		return
+	}
+	g.checked++
+	if expected.Hash != got.Hash {
+		m := g.mismatch[query]
+		if m == nil {
+			m = &goldenMismatch{expected: *expected}
+			g.mismatch[query] = m
+		}
+		m.count++
+		m.last = got
+	}
+}
+
+// Save writes the recorded results.
+func (g *Golden) Save(path string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, err := json.MarshalIndent(goldenFile{Format: g.format, Queries: g.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Mismatches returns the number of responses different from the golden file.
+func (g *Golden) Mismatches() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ret int64
+	for _, m := range g.mismatch {
+		ret += m.count
+	}
+	return ret
+}
+
+func (g *Golden) Print() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.record {
+		unstable := 0
+		for _, e := range g.entries {
+			if e.Unstable {
+				unstable++
+			}
+		}
+		printer.Printf(" Recorded queries: %d, unstable: %d\n", len(g.entries), unstable)
+		return
+	}
+	printer.Printf(" Verified responses: %d, mismatch queries: %d, not in golden file: %d\n",
+		g.checked, len(g.mismatch), g.unknown)
+	queries := make([]string, 0, len(g.mismatch))
+	for q := range g.mismatch {
+		queries = append(queries, q)
+	}
+	sort.Strings(queries)
+	for _, q := range queries {
+		m := g.mismatch[q]
+		printer.Printf("  mismatch %d: rows %d, expected %d, hash %.12s, expected %.12s: %s\n",
+			m.count, m.last.Rows, m.expected.Rows, m.last.Hash, m.expected.Hash, queryLabel(q))
+	}
+}
+
+// queryLabel returns the query in a line of at most 100 characters.
+func queryLabel(query string) string {
+	ret := strings.Join(strings.Fields(query), " ")
+	if len(ret) > 100 {
+		ret = ret[:97] + "..."
+	}
+	return ret
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/tidwall/gjson"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 )
@@ -37,60 +40,232 @@ func main() {
 	useCache := false
 	buffSize := 0
 	delay := "0"
+	queriesPath := ""
+	delimiter := ";"
+	gen := ""
+	recordPath := ""
+	verifyPath := ""
+	readersSpec := ReaderDefault
+	slowStart := time.Duration(0)
+	duration := time.Duration(0)
+	think := "0"
+	format := FormatJSON
+	formatsSpec := ""
 
 	flag.StringVar(&neoHttpAddr, "neo-http", neoHttpAddr, "Neo HTTP address")
 	flag.IntVar(&numberOfWorkers, "n", numberOfWorkers, "Number of workers to use")
 	flag.IntVar(&numberOfRuns, "r", numberOfRuns, "Number of runs")
+	flag.DurationVar(&duration, "duration", duration, "Run until the duration instead of -r runs, e.g. 10m")
+	flag.StringVar(&think, "think", think, "Think time of a worker between its requests: <duration>, uniform:<min>-<max> or exp:<mean>")
 	flag.IntVar(&buffSize, "buff", buffSize, "Buffer size for reading response body")
 	flag.StringVar(&delay, "delay", delay, "Delay for reading response body")
 	flag.StringVar(&scenario, "scenario", scenario, "Scenario to run")
+	flag.StringVar(&queriesPath, "queries", queriesPath, "Query file or directory of *.sql files instead of the scenario")
+	flag.StringVar(&delimiter, "delimiter", delimiter, "Statement delimiter of -queries")
+	flag.StringVar(&gen, "gen", gen, "Generate IN-list queries instead of the scenario, key=value,... of queries, table, meta1, meta2_groups, meta2_group_size, meta2_stride, before (\"default\" for the multi scenario)")
+	flag.StringVar(&format, "format", format, "Response format of the queries: json, csv or ndjson, +gzip for the compressed, e.g. csv+gzip, TQL is always json and refuses the others")
+	flag.StringVar(&formatsSpec, "formats", formatsSpec, "Run every query in each of the response formats and compare them, format[+gzip],... e.g. json,json+gzip,csv,ndjson")
 	flag.BoolVar(&useTql, "tql", useTql, "Use TQL")
 	flag.BoolVar(&useCache, "cache", useCache, "Use cache")
+	flag.StringVar(&recordPath, "record", recordPath, "Record the row count and the hash of the result of every query into the golden file")
+	flag.StringVar(&verifyPath, "verify", verifyPath, "Compare the result of every query with the golden file of -record and report the mismatches")
+	flag.StringVar(&readersSpec, "readers", readersSpec, "Reader profiles of the workers in order, profile[*count],... of default, fast, bandwidth=<size>, stall=<size>/<duration>, abandon=<size>")
+	flag.DurationVar(&slowStart, "slow-start", slowStart, "Delay of the first run of the slow reader profiles, the fast ones run alone until then")
 	flag.Parse()
 
-	sqlTexts := scenarios[scenario]
-	if len(sqlTexts) == 0 {
-		fmt.Println("Unknown scenario:", scenario)
-		os.Exit(1)
+	var sqlTexts []string
+	if queriesPath != "" {
+		queries, err := LoadQueries(queriesPath, delimiter)
+		if err != nil {
+			fmt.Println("Failed to load queries:", err)
+			os.Exit(1)
+		}
+		sqlTexts = append(sqlTexts, queries...)
+	}
+	if gen != "" {
+		spec := gen
+		if spec == "default" {
+			spec = ""
+		}
+		g, err := ParseInListGen(spec)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		sqlTexts = append(sqlTexts, g.Generate()...)
+	}
+	if queriesPath == "" && gen == "" {
+		sqlTexts = scenarios[scenario]
+		if len(sqlTexts) == 0 {
+			fmt.Println("Unknown scenario:", scenario)
+			os.Exit(1)
+		}
 	}
+	fmt.Println("Queries:", len(sqlTexts))
 
 	readBuffSize = buffSize
 	readSleep, _ = time.ParseDuration(delay)
-	runChan := make(chan time.Duration, 1000)
-	queryChan := make(chan time.Duration, 1000)
+	readers, err := ParseReaders(readersSpec)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if readersSpec != ReaderDefault {
+		fmt.Println("Readers:", readersSpec)
+	}
+	// every run of a worker queries in each of the formats
+	var formats []ResponseFormat
+	if formatsSpec != "" {
+		formats, err = ParseFormats(formatsSpec)
+	} else {
+		var rf ResponseFormat
+		rf, err = ParseFormat(format)
+		formats = []ResponseFormat{rf}
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	hasTql := useTql || slices.ContainsFunc(sqlTexts, func(q string) bool { return strings.HasPrefix(q, "/db/tql/") })
+	if len(formats) > 1 {
+		if hasTql {
+			fmt.Println("-formats compares the formats of /db/query, the queries can not be TQL")
+			os.Exit(1)
+		}
+		if recordPath != "" || verifyPath != "" {
+			fmt.Println("-formats can not be given with -record or -verify, the hashes of the formats differ")
+			os.Exit(1)
+		}
+		fmt.Println("Formats:", formatsSpec)
+	} else if hasTql && formats[0] != (ResponseFormat{Format: FormatJSON}) {
+		// the responses of TQL are always json and not compressed
+		fmt.Printf("-format %s can not be given with TQL queries, TQL responds in json\n", formats[0])
+		os.Exit(1)
+	}
+
+	var golden *Golden
+	if recordPath != "" && verifyPath != "" {
+		fmt.Println("-record and -verify can not be given together")
+		os.Exit(1)
+	} else if recordPath != "" {
+		golden = NewGoldenRecorder(formats[0].Format)
+	} else if verifyPath != "" {
+		g, err := LoadGolden(verifyPath)
+		if err != nil {
+			fmt.Println("Failed to load golden file:", err)
+			os.Exit(1)
+		}
+		// the rows of the formats are hashed differently, e.g. a CSV field is always a string
+		if g.Format() != formats[0].Format {
+			fmt.Printf("The golden file %s was recorded with -format %s, -verify needs the same -format\n", verifyPath, g.Format())
+			os.Exit(1)
+		}
+		golden = g
+	}
+	thinkTime, err := ParseThinkTime(think)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if thinkTime.Kind != ThinkFixed || thinkTime.Min > 0 {
+		fmt.Println("Think time:", thinkTime)
+	}
+	var deadline time.Time
+	if duration > 0 {
+		numberOfRuns = 0
+		deadline = time.Now().Add(duration)
+		fmt.Println("Duration:", duration)
+	}
+	// wait sleeps for d or until the deadline, false if the deadline is over
+	wait := func(d time.Duration) bool {
+		if !deadline.IsZero() {
+			d = min(d, time.Until(deadline))
+		}
+		if d > 0 {
+			time.Sleep(d)
+		}
+		return deadline.IsZero() || time.Now().Before(deadline)
+	}
+	sampleChan := make(chan runSample, 1000)
 
-	stat := NewStat(numberOfWorkers, numberOfRuns)
-	stat.Start(runChan, queryChan)
+	stat := NewStat(numberOfWorkers, numberOfRuns, duration, formats)
+	stat.Start(sampleChan)
 
 	wg := sync.WaitGroup{}
 	for i := 0; i < numberOfWorkers; i++ {
 		wg.Add(1)
-		go func(workerId int, queries []string) {
+		go func(workerId int, queries []string, reader ReaderProfile) {
 			defer wg.Done()
 			lenQueries := int32(len(queries))
-			for r := 0; r < numberOfRuns; r++ {
-				start := time.Now()
-
+			if reader.Slow() && slowStart > 0 && !wait(slowStart) {
+				return
+			}
+			for r := 0; duration > 0 || r < numberOfRuns; r++ {
+				if r > 0 && !wait(thinkTime.Next()) {
+					break
+				}
 				sqlText := queries[rand.Int31n(lenQueries)]
-				var queryElapse time.Duration
-				if strings.HasPrefix(sqlText, "/db/tql/") {
-					queryElapse = queryNeoTqlFile(neoHttpAddr, sqlText)
-				} else if useTql {
-					queryElapse = queryNeoTql(neoHttpAddr, sqlText, useCache)
-				} else {
-					queryElapse = queryNeo(neoHttpAddr, sqlText)
+				// rotate the order of the formats, so that no format is always the first to run the query
+				for f := range formats {
+					rf := formats[(r+workerId+f)%len(formats)]
+					start := time.Now()
+					contended := slowOpen.Load() > 0
+					if reader.Slow() {
+						slowOpen.Add(1)
+					}
+
+					var hasher *RowHasher
+					if golden != nil {
+						hasher = &RowHasher{}
+					}
+					var result QueryResult
+					if strings.HasPrefix(sqlText, "/db/tql/") {
+						result = queryNeoTqlFile(neoHttpAddr, sqlText, reader, hasher)
+					} else if useTql {
+						result = queryNeoTql(neoHttpAddr, sqlText, useCache, reader, hasher)
+					} else {
+						result = queryNeo(neoHttpAddr, sqlText, rf, reader, hasher)
+					}
+					if reader.Slow() {
+						slowOpen.Add(-1)
+					}
+					if golden != nil && !result.Abandoned {
+						golden.Add(sqlText, result.Golden)
+					}
+
+					sampleChan <- runSample{
+						reader:    reader,
+						format:    rf,
+						run:       time.Since(start),
+						query:     result.Elapse,
+						firstByte: result.FirstByte,
+						firstRow:  result.FirstRow,
+						lastRow:   result.LastRow,
+						rows:      result.Rows,
+						bytes:     result.Bytes,
+						decode:    result.Decode,
+						contended: contended,
+						abandoned: result.Abandoned,
+					}
 				}
-
-				runElapse := time.Since(start)
-				runChan <- runElapse
-				queryChan <- queryElapse
 			}
-		}(i, sqlTexts)
+		}(i, sqlTexts, readers[i%len(readers)])
 	}
 	wg.Wait()
-	close(runChan)
-	close(queryChan)
+	close(sampleChan)
 	stat.Stop()
+
+	if golden != nil {
+		golden.Print()
+		if recordPath != "" {
+			if err := golden.Save(recordPath); err != nil {
+				fmt.Println("Failed to save golden file:", err)
+				os.Exit(1)
+			}
+		} else if golden.Mismatches() > 0 {
+			os.Exit(1)
+		}
+	}
 }
 
 var client = &http.Client{
@@ -100,116 +275,136 @@ var client = &http.Client{
 	},
 }
 
-func queryNeoTqlFile(neoHttpAddr string, tqlFile string) time.Duration {
-	req, err := http.NewRequest("GET", neoHttpAddr+tqlFile, nil)
-	if err != nil {
-		fmt.Println("Failed to create request:", err)
-		os.Exit(1)
+// slowClient is the client of the slow reader profiles,
+// the connections they hold do not make the fast readers wait for a connection.
+var slowClient = &http.Client{
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 100,
+		MaxConnsPerHost:     100,
+	},
+}
+
+func clientOf(reader ReaderProfile) *http.Client {
+	if reader.Slow() {
+		return slowClient
 	}
-	rsp, err := client.Do(req)
+	return client
+}
+
+// QueryResult is the response of a query, the times are since the request was sent.
+// The rows are not all read if it is Abandoned.
+type QueryResult struct {
+	Elapse    time.Duration // elapsed time that is said in the response JSON, 0 for the other formats
+	FirstByte time.Duration
+	FirstRow  time.Duration // 0 if no rows
+	LastRow   time.Duration // 0 if no rows
+	Rows      int64
+	Bytes     int64         // bytes of the body as sent, compressed or not
+	Decode    time.Duration // time decoding the body, the waits for the body are not included
+	Golden    GoldenEntry   // row count and hash of the rows if the hasher was given
+	Abandoned bool
+}
+
+// doQuery sends the request and decodes the response body of the format row by row
+// as the reader profile reads it. The rows are hashed into the hasher if not nil.
+func doQuery(req *http.Request, reader ReaderProfile, rf ResponseFormat, hasher *RowHasher, failMsg string) QueryResult {
+	result := QueryResult{}
+	var start time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { result.FirstByte = time.Since(start) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	// the body is decompressed here, so that the bytes are counted as sent
+	if rf.Compress != "" {
+		req.Header.Set("Accept-Encoding", rf.Compress)
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	start = time.Now()
+	rsp, err := clientOf(reader).Do(req)
 	if err != nil {
-		fmt.Println("Failed to select data:", err)
+		fmt.Println(failMsg, err)
 		os.Exit(1)
 	}
 	if rsp.StatusCode != http.StatusOK {
-		dumpResponse(rsp, "Failed to select data")
+		dumpResponse(rsp, failMsg+" "+rsp.Status)
 		os.Exit(1)
 	}
+	defer rsp.Body.Close()
 
-	content, err := ReadAll(rsp.Body, readBuffSize, readSleep)
+	body := &meteredReader{r: reader.Reader(rsp.Body)}
+	decodeStart := time.Now()
+	r, err := decompress(rf.Compress, body)
 	if err != nil {
 		fmt.Println("Failed to read response body:", err)
 		os.Exit(1)
 	}
-	rsp.Body.Close()
-
-	jsonStr := string(content)
-	success := gjson.Get(jsonStr, "success").Bool()
-	if !success {
-		reason := gjson.Get(jsonStr, "reason").String()
-		fmt.Println("Failed to select data:", reason)
-		os.Exit(1)
+	meta, err := decodeRows(rf.Format, r, func(row []byte) {
+		result.Rows++
+		result.LastRow = time.Since(start)
+		if result.Rows == 1 {
+			result.FirstRow = result.LastRow
+		}
+		if hasher != nil {
+			hasher.Add(row)
+		}
+	})
+	result.Bytes = body.bytes
+	result.Decode = time.Since(decodeStart) - body.wait
+	if errors.Is(err, errAbandoned) {
+		result.Abandoned = true
+		return result
 	}
-	rows := gjson.Get(jsonStr, "data.rows").Array()
-	_ = rows
-	elapseStr := gjson.Get(jsonStr, "elapse").String()
-	elapse, err := time.ParseDuration(elapseStr)
 	if err != nil {
-		fmt.Println("Failed to parse elapse:", err)
+		fmt.Println("Failed to read response body:", err)
 		os.Exit(1)
 	}
-	return elapse
-}
-
-func ReadAll(r io.Reader, bufSize int, delay time.Duration) ([]byte, error) {
-	if bufSize <= 0 {
-		bufSize = 512
-	}
-	b := make([]byte, 0, bufSize)
-	for {
-		n, err := r.Read(b[len(b):cap(b)])
-		b = b[:len(b)+n]
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return b, err
+	if rf.Format == FormatJSON {
+		if !meta.success {
+			fmt.Println(failMsg, meta.reason)
+			os.Exit(1)
 		}
-
-		if len(b) == cap(b) {
-			// Add more capacity (let append pick how much).
-			b = append(b, 0)[:len(b)]
-		}
-		if delay > 0 {
-			time.Sleep(delay)
+		if result.Elapse, err = time.ParseDuration(meta.elapse); err != nil {
+			fmt.Println("Failed to parse elapse:", err)
+			os.Exit(1)
 		}
 	}
+	if hasher != nil {
+		result.Golden = hasher.Entry()
+	}
+	return result
 }
 
-// execute the query and return the elapsed time that is said in the response JSON.
-func queryNeo(neoHttpAddr string, sqlText string) time.Duration {
-	req, err := http.NewRequest("GET", neoHttpAddr+"/db/query?q="+url.QueryEscape(sqlText), nil)
+// the TQL file is expected to write JSON.
+func queryNeoTqlFile(neoHttpAddr string, tqlFile string, reader ReaderProfile, hasher *RowHasher) QueryResult {
+	req, err := http.NewRequest("GET", neoHttpAddr+tqlFile, nil)
 	if err != nil {
 		fmt.Println("Failed to create request:", err)
 		os.Exit(1)
 	}
-	rsp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Failed to select data:", err)
-		os.Exit(1)
-	}
-	if rsp.StatusCode != http.StatusOK {
-		dumpResponse(rsp, "Failed to select data")
-		os.Exit(1)
-	}
+	return doQuery(req, reader, ResponseFormat{Format: FormatJSON}, hasher, "Failed to select data:")
+}
 
-	content, err := ReadAll(rsp.Body, readBuffSize, readSleep)
-	if err != nil {
-		fmt.Println("Failed to read response body:", err)
-		os.Exit(1)
+// execute the query and return the response of the format read by the reader profile.
+func queryNeo(neoHttpAddr string, sqlText string, rf ResponseFormat, reader ReaderProfile, hasher *RowHasher) QueryResult {
+	params := url.Values{"q": {sqlText}}
+	if rf.Format != FormatJSON {
+		params.Set("format", rf.Format)
+		params.Set("heading", "false")
 	}
-	rsp.Body.Close()
-
-	jsonStr := string(content)
-	success := gjson.Get(jsonStr, "success").Bool()
-	if !success {
-		reason := gjson.Get(jsonStr, "reason").String()
-		fmt.Println("Failed to select data:", reason)
-		os.Exit(1)
+	if rf.Compress != "" {
+		params.Set("compress", rf.Compress)
 	}
-	rows := gjson.Get(jsonStr, "data.rows").Array()
-	_ = rows
-	elapseStr := gjson.Get(jsonStr, "elapse").String()
-	elapse, err := time.ParseDuration(elapseStr)
+	req, err := http.NewRequest("GET", neoHttpAddr+"/db/query?"+params.Encode(), nil)
 	if err != nil {
-		fmt.Println("Failed to parse elapse:", err)
+		fmt.Println("Failed to create request:", err)
 		os.Exit(1)
 	}
-	return elapse
+	return doQuery(req, reader, rf, hasher, "Failed to select data:")
 }
 
-// execute the query and return the elapsed time that is said in the response JSON.
-func queryNeoTql(neoHttpAddr string, sqlText string, useCache bool) time.Duration {
+// execute the query by TQL of the JSON sink and return the response read by the reader profile.
+func queryNeoTql(neoHttpAddr string, sqlText string, useCache bool, reader ReaderProfile, hasher *RowHasher) QueryResult {
 	var code string
 	var useJSMem bool
 	if sqlText == "@fake" {
@@ -240,39 +435,7 @@ req:
 		fmt.Println("Failed to create request:", err)
 		os.Exit(1)
 	}
-	rsp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Failed to request data:", err)
-		os.Exit(1)
-	}
-	if rsp.StatusCode != http.StatusOK {
-		dumpResponse(rsp, "Failed to response data: "+rsp.Status)
-		os.Exit(1)
-	}
-
-	content, err := ReadAll(rsp.Body, readBuffSize, readSleep)
-	if err != nil {
-		fmt.Println("Failed to read response body:", err)
-		os.Exit(1)
-	}
-	rsp.Body.Close()
-
-	jsonStr := string(content)
-	success := gjson.Get(jsonStr, "success").Bool()
-	if !success {
-		reason := gjson.Get(jsonStr, "reason").String()
-		fmt.Println("Failed to read data:", reason)
-		os.Exit(1)
-	}
-	rows := gjson.Get(jsonStr, "data.rows").Array()
-	_ = rows
-	elapseStr := gjson.Get(jsonStr, "elapse").String()
-	elapse, err := time.ParseDuration(elapseStr)
-	if err != nil {
-		fmt.Println("Failed to parse elapse:", err)
-		os.Exit(1)
-	}
-	return elapse
+	return doQuery(req, reader, ResponseFormat{Format: FormatJSON}, hasher, "Failed to read data:")
 }
 
 func dumpResponse(rsp *http.Response, msg string) {
@@ -286,87 +449,344 @@ func dumpResponse(rsp *http.Response, msg string) {
 	io.Copy(os.Stdout, rsp.Body)
 }
 
+// runSample is a query run of a worker.
+type runSample struct {
+	reader    ReaderProfile
+	format    ResponseFormat
+	run       time.Duration // from the request until the body is read
+	query     time.Duration // elapsed time that is said in the response JSON
+	firstByte time.Duration
+	firstRow  time.Duration // 0 if no rows
+	lastRow   time.Duration // 0 if no rows
+	rows      int64
+	bytes     int64
+	decode    time.Duration
+	contended bool // slow readers were reading responses when the run started
+	abandoned bool
+}
+
+// durationStat is the sum, min and max of durations.
+type durationStat struct {
+	count int64
+	sum   time.Duration
+	min   time.Duration
+	max   time.Duration
+}
+
+func (ds *durationStat) add(d time.Duration) {
+	ds.count++
+	ds.sum += d
+	if ds.count == 1 || d < ds.min {
+		ds.min = d
+	}
+	if d > ds.max {
+		ds.max = d
+	}
+}
+
+func (ds *durationStat) avg() time.Duration {
+	return avgOf(ds.sum, ds.count)
+}
+
 type Stat struct {
 	runCount      int64
 	prevRunCount  int64
 	runElapsedSum time.Duration
 	runElapseMin  time.Duration
 	runElapseMax  time.Duration
+	abandonCount  int64 // runs whose body was abandoned, they are not in the elapsed times
 
+	queryCount      int64 // runs of JSON, the other formats do not say the elapsed time
 	queryElapsedSum time.Duration
 	queryElapsedMin time.Duration
 	queryElapsedMax time.Duration
 
+	// streaming of the responses, the row times of the runs of rows
+	firstByte durationStat
+	firstRow  durationStat
+	lastRow   durationStat
+	rowCount  int64
+
+	readers map[string]*readerStat // reader profile name -> stat
+
+	formats     []ResponseFormat // the formats of every run, in the order of -formats
+	formatStats map[ResponseFormat]*formatStat
+
 	startTime time.Time
-	closeCh   chan struct{}
 	closeWg   sync.WaitGroup
 	ticker    *time.Ticker
 
-	workers int
-	runs    int
+	workers  int
+	runs     int           // runs per worker, 0 if the run is by duration
+	duration time.Duration // 0 if the run is by runs
+}
+
+type readerStat struct {
+	slow          bool
+	runCount      int64
+	prevRunCount  int64
+	runElapsedSum time.Duration
+	prevSum       time.Duration
+	runElapseMin  time.Duration
+	runElapseMax  time.Duration
+	abandonCount  int64
+	// the runs of a fast profile while no slow reader was reading a response, and while one was
+	baselineCount  int64
+	baselineSum    time.Duration
+	contendedCount int64
+	contendedSum   time.Duration
 }
 
-func NewStat(worker, run int) *Stat {
+// formatStat compares a response format with the others of -formats.
+type formatStat struct {
+	runCount  int64
+	elapse    durationStat // of JSON only, the other formats do not say it
+	firstByte durationStat
+	http      durationStat
+	decode    durationStat
+	bytes     int64
+	rows      int64
+}
+
+func NewStat(worker, run int, duration time.Duration, formats []ResponseFormat) *Stat {
 	return &Stat{
-		closeCh:   make(chan struct{}),
-		ticker:    time.NewTicker(10 * time.Second),
-		startTime: time.Now(),
-		workers:   worker,
-		runs:      run,
+		readers:     map[string]*readerStat{},
+		formats:     formats,
+		formatStats: map[ResponseFormat]*formatStat{},
+		ticker:      time.NewTicker(10 * time.Second),
+		startTime:   time.Now(),
+		workers:     worker,
+		runs:        run,
+		duration:    duration,
 	}
 }
 
-func (s *Stat) Start(runCh chan time.Duration, queryCh chan time.Duration) {
+// Start collects the samples until the channel is closed.
+func (s *Stat) Start(sampleCh chan runSample) {
 	s.closeWg.Add(1)
 	go func() {
 		defer s.closeWg.Done()
 		for {
 			select {
-			case d := <-runCh:
-				s.runCount++
-				s.runElapsedSum += d
-				if s.runElapseMin == 0 || d < s.runElapseMin {
-					s.runElapseMin = d
-				}
-				if d > s.runElapseMax {
-					s.runElapseMax = d
-				}
-			case d := <-queryCh:
-				s.queryElapsedSum += d
-				if s.queryElapsedMin == 0 || d < s.queryElapsedMin {
-					s.queryElapsedMin = d
-				}
-				if d > s.queryElapsedMax {
-					s.queryElapsedMax = d
+			case smp, ok := <-sampleCh:
+				if !ok {
+					return
 				}
+				s.add(smp)
 			case <-s.ticker.C:
 				s.Print()
-			case <-s.closeCh:
-				return
 			}
 		}
 	}()
 }
 
+func (s *Stat) add(smp runSample) {
+	s.runCount++
+	rs := s.readers[smp.reader.Name]
+	if rs == nil {
+		rs = &readerStat{slow: smp.reader.Slow()}
+		s.readers[smp.reader.Name] = rs
+	}
+	rs.runCount++
+	fs := s.formatStats[smp.format]
+	if fs == nil {
+		fs = &formatStat{}
+		s.formatStats[smp.format] = fs
+	}
+	if smp.abandoned {
+		s.abandonCount++
+		rs.abandonCount++
+		return
+	}
+	d := smp.run
+	s.runElapsedSum += d
+	if s.runElapseMin == 0 || d < s.runElapseMin {
+		s.runElapseMin = d
+	}
+	if d > s.runElapseMax {
+		s.runElapseMax = d
+	}
+	if smp.format.Format == FormatJSON {
+		s.queryCount++
+		s.queryElapsedSum += smp.query
+		if s.queryElapsedMin == 0 || smp.query < s.queryElapsedMin {
+			s.queryElapsedMin = smp.query
+		}
+		if smp.query > s.queryElapsedMax {
+			s.queryElapsedMax = smp.query
+		}
+	}
+	s.firstByte.add(smp.firstByte)
+	if smp.rows > 0 {
+		s.firstRow.add(smp.firstRow)
+		s.lastRow.add(smp.lastRow)
+		s.rowCount += smp.rows
+	}
+	fs.runCount++
+	if smp.format.Format == FormatJSON {
+		fs.elapse.add(smp.query)
+	}
+	fs.firstByte.add(smp.firstByte)
+	fs.http.add(d)
+	fs.decode.add(smp.decode)
+	fs.bytes += smp.bytes
+	fs.rows += smp.rows
+	rs.runElapsedSum += d
+	if rs.runElapseMin == 0 || d < rs.runElapseMin {
+		rs.runElapseMin = d
+	}
+	if d > rs.runElapseMax {
+		rs.runElapseMax = d
+	}
+	if smp.contended {
+		rs.contendedCount++
+		rs.contendedSum += d
+	} else {
+		rs.baselineCount++
+		rs.baselineSum += d
+	}
+}
+
+// Stop waits until the sample channel is closed and drained.
 func (s *Stat) Stop() {
-	close(s.closeCh)
 	s.closeWg.Wait()
+	s.ticker.Stop()
 	s.Print()
+	s.printContention()
+	s.printFormats()
 }
 
 var printer = message.NewPrinter(language.English)
 
+func avgOf(sum time.Duration, n int64) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return sum / time.Duration(n)
+}
+
 func (s *Stat) Print() {
 	thisRunCount := s.runCount - s.prevRunCount
 
-	printer.Println(" Elapsed:", time.Since(s.startTime), "Workers:", s.workers, "Runs:", s.runs)
+	if s.duration > 0 {
+		printer.Println(" Elapsed:", time.Since(s.startTime), "Workers:", s.workers, "Duration:", s.duration)
+	} else {
+		printer.Println(" Elapsed:", time.Since(s.startTime), "Workers:", s.workers, "Runs:", s.runs)
+	}
 	if s.runCount == 0 {
 		return
 	}
-	printer.Println(" Query runs:", s.runCount, "/", s.workers*s.runs, ", This cycle:", thisRunCount)
-	printer.Println(" http   avg:", s.runElapsedSum/time.Duration(s.runCount), "min:", s.runElapseMin, "max:", s.runElapseMax)
-	printer.Println(" query  avg:", s.queryElapsedSum/time.Duration(s.runCount), "min:", s.queryElapsedMin, "max:", s.queryElapsedMax)
+	if s.duration > 0 {
+		printer.Printf(" Query runs: %d , This cycle: %d, %.1f/s\n", s.runCount, thisRunCount, float64(s.runCount)/time.Since(s.startTime).Seconds())
+	} else {
+		printer.Println(" Query runs:", s.runCount, "/", s.workers*s.runs*len(s.formats), ", This cycle:", thisRunCount)
+	}
+	if completed := s.runCount - s.abandonCount; completed > 0 {
+		printer.Println(" http   avg:", s.runElapsedSum/time.Duration(completed), "min:", s.runElapseMin, "max:", s.runElapseMax)
+		// only JSON says the elapsed time of the server, ttfb is the stand-in of the other formats
+		if s.queryCount == 0 {
+			printer.Printf(" query  avg: -, the elapsed time is not said by %s, ttfb is the stand-in\n", s.nonJSONFormats())
+		} else if s.queryCount < completed {
+			printer.Println(" query  avg:", avgOf(s.queryElapsedSum, s.queryCount), "min:", s.queryElapsedMin, "max:", s.queryElapsedMax,
+				printer.Sprintf("of %d json runs, ttfb is the stand-in of %s", s.queryCount, s.nonJSONFormats()))
+		} else {
+			printer.Println(" query  avg:", avgOf(s.queryElapsedSum, s.queryCount), "min:", s.queryElapsedMin, "max:", s.queryElapsedMax)
+		}
+		printer.Println(" ttfb   avg:", s.firstByte.avg(), "min:", s.firstByte.min, "max:", s.firstByte.max)
+		if s.rowCount > 0 {
+			printer.Println(" first row avg:", s.firstRow.avg(), "min:", s.firstRow.min, "max:", s.firstRow.max)
+			printer.Println(" last row  avg:", s.lastRow.avg(), "min:", s.lastRow.min, "max:", s.lastRow.max)
+			printer.Println(" rows:", s.rowCount, "avg:", s.rowCount/completed, "per run")
+		}
+	}
+	if s.abandonCount > 0 {
+		printer.Println(" abandoned:", s.abandonCount)
+	}
+	if len(s.readers) > 1 || s.readers[ReaderDefault] == nil {
+		names := make([]string, 0, len(s.readers))
+		for name := range s.readers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rs := s.readers[name]
+			completed := rs.runCount - rs.abandonCount
+			cycleCompleted := completed - rs.prevRunCount
+			printer.Printf(" reader %-20s runs: %d, this cycle: %d, http avg: %v min: %v max: %v, this cycle avg: %v",
+				name, rs.runCount, cycleCompleted, avgOf(rs.runElapsedSum, completed), rs.runElapseMin, rs.runElapseMax,
+				avgOf(rs.runElapsedSum-rs.prevSum, cycleCompleted))
+			if rs.abandonCount > 0 {
+				printer.Printf(", abandoned: %d", rs.abandonCount)
+			}
+			printer.Println()
+			rs.prevRunCount, rs.prevSum = completed, rs.runElapsedSum
+		}
+		printer.Println(" slow responses open:", slowOpen.Load())
+	}
 	fmt.Println()
 
 	s.prevRunCount = s.runCount
 }
+
+// printContention compares the http time of the fast profiles
+// without and with slow readers reading responses.
+func (s *Stat) printContention() {
+	names := make([]string, 0, len(s.readers))
+	for name, rs := range s.readers {
+		if !rs.slow && rs.contendedCount > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rs := s.readers[name]
+		printer.Printf(" reader %s http avg without slow readers: %v (%d runs), with slow readers open: %v (%d runs)\n",
+			name, avgOf(rs.baselineSum, rs.baselineCount), rs.baselineCount, avgOf(rs.contendedSum, rs.contendedCount), rs.contendedCount)
+	}
+	if len(names) > 0 {
+		fmt.Println()
+	}
+}
+
+// printFormats compares the completed runs of the formats of -formats.
+func (s *Stat) printFormats() {
+	if len(s.formats) < 2 {
+		return
+	}
+	printer.Printf(" %-12s %8s %14s %14s %14s %14s %14s %12s\n",
+		"format", "runs", "elapse avg", "ttfb avg", "http avg", "decode avg", "bytes avg", "bytes/row")
+	for _, rf := range s.formats {
+		fs := s.formatStats[rf]
+		if fs == nil || fs.runCount == 0 {
+			printer.Printf(" %-12s %8d\n", rf, 0)
+			continue
+		}
+		// marked as the ttfb for the formats not saying the elapsed time
+		elapse := fs.firstByte.avg().Round(time.Microsecond).String() + "*"
+		if fs.elapse.count > 0 {
+			elapse = fs.elapse.avg().String()
+		}
+		perRow := "-"
+		if fs.rows > 0 {
+			perRow = printer.Sprintf("%.1f", float64(fs.bytes)/float64(fs.rows))
+		}
+		printer.Printf(" %-12s %8d %14s %14v %14v %14v %14d %12s\n",
+			rf, fs.runCount, elapse, fs.firstByte.avg().Round(time.Microsecond), fs.http.avg().Round(time.Microsecond),
+			fs.decode.avg().Round(time.Microsecond), fs.bytes/fs.runCount, perRow)
+	}
+	if slices.ContainsFunc(s.formats, func(rf ResponseFormat) bool { return rf.Format != FormatJSON }) {
+		printer.Printf(" * ttfb, the elapsed time is not said by %s\n", s.nonJSONFormats())
+	}
+	fmt.Println()
+}
+
+// nonJSONFormats returns the names of the formats of the runs not saying the elapsed time, e.g. "csv, ndjson".
+func (s *Stat) nonJSONFormats() string {
+	names := []string{}
+	for _, rf := range s.formats {
+		if rf.Format != FormatJSON && !slices.Contains(names, rf.Format) {
+			names = append(names, rf.Format)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		text      string
+		delimiter string
+		want      []string
+	}{
+		{"select 1;\nselect 2;", ";", []string{"select 1", "select 2"}},
+		{"select 1;;\n\n;select 2", ";", []string{"select 1", "select 2"}},
+		{"-- don't\nselect 1;\nselect 2;", ";", []string{"select 1", "select 2"}},
+		{"select 1; -- a; b\nselect 2", ";", []string{"select 1", "select 2"}},
+		{"select 1 -- trailing 'comment\nfrom t;", ";", []string{"select 1 \nfrom t"}},
+		{"select 'a;b', 'it''s';select '--x'", ";", []string{"select 'a;b', 'it''s'", "select '--x'"}},
+		{"-- only a comment", ";", []string{}},
+		{"select 1\nGO\nselect ';GO'\nGO", "GO", []string{"select 1", "select ';GO'"}},
+		{"select 1 $$ select 2 $$", "$$", []string{"select 1", "select 2"}},
+		{"select 1; select 2", "", []string{"select 1; select 2"}},
+	}
+	for _, tt := range tests {
+		if got := SplitStatements(tt.text, tt.delimiter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q by %q: %q, want %q", tt.text, tt.delimiter, got, tt.want)
+		}
+	}
+}
+
+func TestInListGen(t *testing.T) {
+	// the queries of the multi scenario before it was generated,
+	// the last line of a query may differ by a trailing newline
+	b, err := os.ReadFile("testdata/multi.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Split(strings.TrimSuffix(string(b), ";\n"), ";\n")
+	got := DefaultInListGen().Generate()
+	if len(got) != len(want) {
+		t.Fatalf("multi: %d queries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if strings.TrimSpace(got[i]) != strings.TrimSpace(want[i]) {
+			t.Errorf("multi %d:\n%s\nwant\n%s", i, got[i], want[i])
+		}
+	}
+
+	g, err := ParseInListGen("queries=3, meta1=2, meta2_groups=1, meta2_group_size=2, table=t2, before=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = g.Generate()
+	if len(got) != 3 {
+		t.Fatalf("queries=3: %d", len(got))
+	}
+	for i, tt := range []struct {
+		has, not []string
+	}{
+		{[]string{"from t2 ", "'m1-0001'", "'m1-0002'", "'m2-0001'", "'m2-0002'"}, []string{"'m1-0003'", "'m2-0003'", "time <"}},
+		{[]string{"'m1-0003'", "'m1-0004'"}, []string{"'m1-0002'", "'m1-0005'"}},
+	} {
+		for _, s := range tt.has {
+			if !strings.Contains(got[i], s) {
+				t.Errorf("query %d has no %s: %s", i, s, got[i])
+			}
+		}
+		for _, s := range tt.not {
+			if strings.Contains(got[i], s) {
+				t.Errorf("query %d has %s: %s", i, s, got[i])
+			}
+		}
+	}
+	for _, str := range []string{"queries", "queries=0", "meta1=x", "rows=10"} {
+		if _, err := ParseInListGen(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+}
+
+func TestLoadQueries(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"b.sql":   "select 3;\n-- the last\nselect 4;",
+		"a.sql":   "select 1;select 2;",
+		"c.txt":   "select 5;",
+		"e.empty": "",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := LoadQueries(dir, ";")
+	if want := []string{"select 1", "select 2", "select 3", "select 4"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("dir: %q, %v", got, err)
+	}
+	got, err = LoadQueries(filepath.Join(dir, "c.txt"), ";")
+	if want := []string{"select 5"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("file: %q, %v", got, err)
+	}
+	if _, err := LoadQueries(filepath.Join(dir, "e.empty"), ";"); err == nil {
+		t.Error("empty: expected error")
+	}
+	if _, err := LoadQueries(filepath.Join(dir, "none.sql"), ";"); err == nil {
+		t.Error("none: expected error")
+	}
+	if len(scenarios["simple"]) == 0 || len(scenarios["multi"]) != 40 {
+		t.Errorf("bundled: simple %d, multi %d", len(scenarios["simple"]), len(scenarios["multi"]))
+	}
+}
+
+func TestRowHasher(t *testing.T) {
+	hash := func(rows ...string) GoldenEntry {
+		h := &RowHasher{}
+		for _, r := range rows {
+			h.Add([]byte(r))
+		}
+		return h.Entry()
+	}
+	a := hash(`["a",1,1.5]`, `["b",2,2.5]`)
+	if a.Rows != 2 || len(a.Hash) != 64 {
+		t.Fatalf("entry: %+v", a)
+	}
+	if b := hash(`[ "b", 2, 2.5 ]`, "[\"a\",\n1,1.5]"); b != a {
+		t.Errorf("order and spaces: %+v, want %+v", b, a)
+	}
+	if b := hash(`["a",1,1.5]`, `["b",2,2.6]`); b.Hash == a.Hash {
+		t.Error("a different row has the same hash")
+	}
+	if b := hash(`["a",1,1.5]`, `["a",1,1.5]`, `["b",2,2.5]`); b.Hash == a.Hash || b.Rows != 3 {
+		t.Errorf("a duplicated row: %+v", b)
+	}
+	if e := hash(); e.Rows != 0 || e.Hash != strings.Repeat("0", 64) {
+		t.Errorf("no rows: %+v", e)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	one := GoldenEntry{Rows: 1, Hash: "01"}
+	two := GoldenEntry{Rows: 2, Hash: "02"}
+	rec := NewGoldenRecorder(FormatCSV)
+	rec.Add("q1", one)
+	rec.Add("q1", one)
+	rec.Add("q2", one)
+	rec.Add("q2", two)
+	path := filepath.Join(t.TempDir(), "golden.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	g, err := LoadGolden(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Format() != FormatCSV {
+		t.Errorf("format: %s", g.Format())
+	}
+	if e := g.entries["q1"]; e == nil || *e != one {
+		t.Errorf("q1: %+v", e)
+	}
+	if e := g.entries["q2"]; e == nil || !e.Unstable || e.Hash != one.Hash {
+		t.Errorf("q2 is not unstable: %+v", e)
+	}
+
+	g.Add("q1", one)
+	g.Add("q1", two)
+	g.Add("q1", two)
+	g.Add("q2", two) // unstable, not verified
+	g.Add("q3", one) // not in the golden file
+	if g.checked != 3 || g.unknown != 1 || g.Mismatches() != 2 {
+		t.Errorf("verify: checked=%d unknown=%d mismatches=%d", g.checked, g.unknown, g.Mismatches())
+	}
+	if m := g.mismatch["q1"]; m == nil || m.last != two || m.expected != one {
+		t.Errorf("mismatch: %+v", m)
+	}
+	for _, text := range []string{"{", `{"q1":{"rows":1,"hash":"01"}}`, `{"format":"json"}`} {
+		os.WriteFile(path, []byte(text), 0644)
+		if _, err := LoadGolden(path); err == nil {
+			t.Errorf("golden file %s: expected error", text)
+		}
+	}
+}
+
+func TestParseReaders(t *testing.T) {
+	got, err := ParseReaders("fast*2, bandwidth=64KB,stall=4kb/5s,abandon=1MB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReaderProfile{
+		{Name: "fast", Kind: ReaderFast},
+		{Name: "fast", Kind: ReaderFast},
+		{Name: "bandwidth=64KB", Kind: ReaderBandwidth, Bytes: 64 << 10},
+		{Name: "stall=4kb/5s", Kind: ReaderStall, Bytes: 4 << 10, Stall: 5 * time.Second},
+		{Name: "abandon=1MB", Kind: ReaderAbandon, Bytes: 1 << 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readers: %+v", got)
+	}
+	for _, str := range []string{"fast*0", "fast*x", "slow", "fast=1", "bandwidth=0", "bandwidth", "stall=4KB", "stall=4KB/0s", "abandon=-1"} {
+		if _, err := ParseReaders(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+	for _, tt := range []struct {
+		str  string
+		want int64
+	}{
+		{"10", 10}, {"10B", 10}, {"2kb", 2 << 10}, {" 3MB ", 3 << 20}, {"1GB", 1 << 30},
+	} {
+		if got, err := parseSize(tt.str); err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v", tt.str, got, err)
+		}
+	}
+	if _, err := parseSize("1TB"); err == nil {
+		t.Error("1TB: expected error")
+	}
+}
+
+func TestReaderProfiles(t *testing.T) {
+	defer func(size int, sleep time.Duration) { readBuffSize, readSleep = size, sleep }(readBuffSize, readSleep)
+	body := bytes.Repeat([]byte("0123456789"), 20)
+	read := func(spec string) (int, error, time.Duration) {
+		p, err := parseReader(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		b, err := io.ReadAll(p.Reader(bytes.NewReader(body)))
+		return len(b), err, time.Since(start)
+	}
+
+	readBuffSize, readSleep = 0, 0
+	if n, err, _ := read("fast"); n != len(body) || err != nil {
+		t.Errorf("fast: %d %v", n, err)
+	}
+	if p, _ := parseReader("fast"); p.Slow() {
+		t.Error("fast is slow")
+	}
+	if n, err, _ := read("abandon=15"); n != 15 || !errors.Is(err, errAbandoned) {
+		t.Errorf("abandon: %d %v", n, err)
+	}
+	if n, err, d := read("stall=50/30ms"); n != len(body) || err != nil || d < 30*time.Millisecond {
+		t.Errorf("stall: %d %v %v", n, err, d)
+	}
+	// 200 bytes at 1000 bytes per second
+	if n, err, d := read("bandwidth=1000"); n != len(body) || err != nil || d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("bandwidth: %d %v %v", n, err, d)
+	}
+
+	readBuffSize, readSleep = 7, time.Millisecond
+	p, _ := parseReader("default")
+	if !p.Slow() {
+		t.Error("default with -delay is not slow")
+	}
+	r := p.Reader(bytes.NewReader(body))
+	if n, _ := r.Read(make([]byte, 100)); n != 7 {
+		t.Errorf("default reads %d, -buff 7", n)
+	}
+	if n, err, d := read("default"); n != len(body) || err != nil || d < 25*time.Millisecond {
+		t.Errorf("default: %d %v %v", n, err, d)
+	}
+	readBuffSize, readSleep = 0, 0
+	if p.Slow() {
+		t.Error("default without -delay is slow")
+	}
+	if n, _ := p.Reader(bytes.NewReader(body)).Read(make([]byte, 1000)); n != 200 {
+		t.Errorf("default reads %d without -buff", n)
+	}
+}
+
+func TestParseThinkTime(t *testing.T) {
+	for _, tt := range []struct {
+		str  string
+		want ThinkTime
+	}{
+		{"0", ThinkTime{Kind: ThinkFixed}},
+		{"2s", ThinkTime{Kind: ThinkFixed, Min: 2 * time.Second}},
+		{"uniform:1s-5s", ThinkTime{Kind: ThinkUniform, Min: time.Second, Max: 5 * time.Second}},
+		{"exp:3s", ThinkTime{Kind: ThinkExp, Min: 3 * time.Second}},
+	} {
+		if got, err := ParseThinkTime(tt.str); err != nil || got != tt.want {
+			t.Errorf("%q: %+v, %v", tt.str, got, err)
+		}
+	}
+	for _, str := range []string{"", "-1s", "uniform:5s-1s", "uniform:1s", "exp:0s", "normal:1s"} {
+		if _, err := ParseThinkTime(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+	uniform, _ := ParseThinkTime("uniform:10ms-20ms")
+	exp, _ := ParseThinkTime("exp:10ms")
+	var sum time.Duration
+	for range 2000 {
+		if d := uniform.Next(); d < 10*time.Millisecond || d >= 20*time.Millisecond {
+			t.Fatalf("uniform: %v", d)
+		}
+		d := exp.Next()
+		if d < 0 {
+			t.Fatalf("exp: %v", d)
+		}
+		sum += d
+	}
+	if mean := sum / 2000; mean < 8*time.Millisecond || mean > 12*time.Millisecond {
+		t.Errorf("exp mean: %v", mean)
+	}
+}
+
+func TestDecodeRows(t *testing.T) {
+	decode := func(format string, body string) ([]string, responseMeta, error) {
+		rows := []string{}
+		meta, err := decodeRows(format, strings.NewReader(body), func(row []byte) {
+			rows = append(rows, string(row))
+		})
+		return rows, meta, err
+	}
+	tests := []struct {
+		format string
+		body   string
+		rows   []string
+		meta   responseMeta
+	}{
+		{FormatJSON, `{"data":{"columns":["NAME","V"],"types":["string","double"],"rows":[["a",1],["b",{"x":[2]}]]},"success":true,"reason":"success","elapse":"1.5ms"}`,
+			[]string{`["a",1]`, `["b",{"x":[2]}]`}, responseMeta{success: true, reason: "success", elapse: "1.5ms"}},
+		{FormatJSON, `{"success":true,"reason":"ok","data":{"rows":[]},"elapse":"1ms","extra":[1,{"a":2}]}`,
+			[]string{}, responseMeta{success: true, reason: "ok", elapse: "1ms"}},
+		{FormatJSON, `{"success":false,"reason":"table not found","elapse":"10µs"}`,
+			[]string{}, responseMeta{reason: "table not found", elapse: "10µs"}},
+		{FormatCSV, "a,1,1.5\n\"b,c\",2,\"say \"\"hi\"\"\"\n",
+			[]string{`["a","1","1.5"]`, `["b,c","2","say \"hi\""]`}, responseMeta{success: true}},
+		{FormatNDJSON, "{\"NAME\":\"a\",\"V\":1}\n\n{\"NAME\":\"b\",\"V\":2}",
+			[]string{`{"NAME":"a","V":1}`, `{"NAME":"b","V":2}`}, responseMeta{success: true}},
+		{FormatCSV, "", []string{}, responseMeta{success: true}},
+	}
+	for _, tt := range tests {
+		rows, meta, err := decode(tt.format, tt.body)
+		if err != nil || !reflect.DeepEqual(rows, tt.rows) || meta != tt.meta {
+			t.Errorf("%s %s: %q %+v %v", tt.format, tt.body, rows, meta, err)
+		}
+	}
+	for _, body := range []string{``, `[]`, `{"data":{"rows":[["a"],`, `{"data":[]}`, `{"success":"yes"}`} {
+		if _, _, err := decode(FormatJSON, body); err == nil {
+			t.Errorf("json %q: expected error", body)
+		}
+	}
+	if _, _, err := decode(FormatCSV, "a,\"b\n"); err == nil {
+		t.Error("csv: expected error")
+	}
+	if _, _, err := decode(FormatNDJSON, strings.Repeat("x", ndjsonMaxLine+1)); err == nil {
+		t.Error("ndjson: expected error of a long line")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("a,1\n"))
+	zw.Close()
+	for _, tt := range []struct {
+		compress string
+		body     []byte
+	}{
+		{CompressGzip, buf.Bytes()},
+		{CompressGzip, []byte("a,1\n")}, // not compressed by the server
+		{"", []byte("a,1\n")},
+		{CompressGzip, []byte("a")},
+	} {
+		r, err := decompress(tt.compress, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := io.ReadAll(r); err != nil || !strings.HasPrefix("a,1\n", string(b)) || len(b) == 0 {
+			t.Errorf("%q %q: %q %v", tt.compress, tt.body, b, err)
+		}
+	}
+	mr := &meteredReader{r: bytes.NewReader(buf.Bytes())}
+	io.ReadAll(mr)
+	if mr.bytes != int64(buf.Len()) {
+		t.Errorf("metered bytes: %d, want %d", mr.bytes, buf.Len())
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	got, err := ParseFormats("json, csv+gzip,ndjson")
+	want := []ResponseFormat{{Format: FormatJSON}, {Format: FormatCSV, Compress: CompressGzip}, {Format: FormatNDJSON}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("formats: %+v %v", got, err)
+	}
+	if got[1].String() != "csv+gzip" || got[0].String() != "json" {
+		t.Errorf("string: %s %s", got[0], got[1])
+	}
+	for _, str := range []string{"xml", "json+zstd", "json,json", "csv,"} {
+		if _, err := ParseFormats(str); err == nil {
+			t.Errorf("-formats %q: expected error", str)
+		}
+	}
+	if rf, err := ParseFormat("ndjson+gzip"); err != nil || rf.Compress != CompressGzip {
+		t.Errorf("-format ndjson+gzip: %+v %v", rf, err)
+	}
+	if _, err := ParseFormat("json,csv"); err == nil {
+		t.Error("-format json,csv: expected error")
+	}
+}
+
+func TestNonJSONFormats(t *testing.T) {
+	for _, tt := range []struct {
+		formats string
+		want    string
+	}{
+		{"json,json+gzip", ""},
+		{"csv", "csv"},
+		{"json,csv,ndjson+gzip,csv+gzip", "csv, ndjson"},
+	} {
+		formats, err := ParseFormats(tt.formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (&Stat{formats: formats}).nonJSONFormats(); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.formats, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bundled query corpora, every file is the scenario of its base name
+//
+//go:embed queries/*.sql
+var bundledQueries embed.FS
+
+func init() {
+	entries, _ := bundledQueries.ReadDir("queries")
+	for _, ent := range entries {
+		b, _ := bundledQueries.ReadFile(path.Join("queries", ent.Name()))
+		scenarios[strings.TrimSuffix(ent.Name(), ".sql")] = SplitStatements(string(b), ";")
+	}
+	scenarios["multi"] = DefaultInListGen().Generate()
+	scenarios["fake"] = []string{"@fake"}
+	scenarios["simple.tql"] = []string{"/db/tql/simple.tql"}
+}
+
+// LoadQueries loads the statements of a file, or of the *.sql files of a directory
+// in the order of their names, split by the delimiter.
+func LoadQueries(name string, delimiter string) ([]string, error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{name}
+	if st.IsDir() {
+		files, err = fs.Glob(os.DirFS(name), "*.sql")
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for i, f := range files {
+			files[i] = filepath.Join(name, f)
+		}
+	}
+	ret := []string{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, SplitStatements(string(b), delimiter)...)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no statements in %s", name)
+	}
+	return ret, nil
+}
+
+// SplitStatements splits the text by the delimiter out of single quoted strings.
+// The "--" comments out of the quoted strings are removed to the end of the line,
+// so a quote or a delimiter in a comment does not split, and the empty statements are skipped.
+func SplitStatements(text string, delimiter string) []string {
+	ret := []string{}
+	stmt := strings.Builder{}
+	add := func() {
+		if s := strings.TrimSpace(stmt.String()); s != "" {
+			ret = append(ret, s)
+		}
+		stmt.Reset()
+	}
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\'':
+			quoted = !quoted
+		case quoted:
+		case strings.HasPrefix(text[i:], "--"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				i = len(text)
+				continue
+			}
+			// the newline is kept
+			i += end - 1
+			continue
+		case delimiter != "" && strings.HasPrefix(text[i:], delimiter):
+			add()
+			i += len(delimiter) - 1
+			continue
+		}
+		stmt.WriteByte(text[i])
+	}
+	add()
+	return ret
+}
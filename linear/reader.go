@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Reader profiles of the response body of a worker.
+//
+//   - default: reads by -buff and sleeps -delay after every read
+//   - fast: reads the body at once
+//   - bandwidth=<size>: reads at most <size> per second, e.g. bandwidth=64KB
+//   - stall=<size>/<duration>: reads <size>, stalls for <duration> then reads the rest, e.g. stall=4KB/5s
+//   - abandon=<size>: reads <size> and closes the body without reading the rest, e.g. abandon=1KB
+const (
+	ReaderDefault   = "default"
+	ReaderFast      = "fast"
+	ReaderBandwidth = "bandwidth"
+	ReaderStall     = "stall"
+	ReaderAbandon   = "abandon"
+)
+
+type ReaderProfile struct {
+	Name  string // the spec of the profile, e.g. "stall=4KB/5s"
+	Kind  string
+	Bytes int64 // bytes per second of bandwidth, bytes before the stall or the abandon
+	Stall time.Duration
+}
+
+// Slow is true if the profile holds the connection longer than the server needs to respond.
+func (p ReaderProfile) Slow() bool {
+	switch p.Kind {
+	case ReaderFast:
+		return false
+	case ReaderDefault:
+		return readSleep > 0
+	}
+	return true
+}
+
+// slowOpen is the number of the responses being read by slow profiles.
+var slowOpen atomic.Int64
+
+// ParseReaders parses the -readers flag, a comma separated list of profile[*count],
+// and returns the profiles of the workers in order, e.g. "fast*6,bandwidth=64KB*2,abandon=1KB".
+func ParseReaders(str string) ([]ReaderProfile, error) {
+	ret := []ReaderProfile{}
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		count := 1
+		if spec, n, ok := strings.Cut(item, "*"); ok {
+			c, err := strconv.Atoi(n)
+			if err != nil || c <= 0 {
+				return nil, fmt.Errorf("invalid -readers count %q", item)
+			}
+			item, count = spec, c
+		}
+		p, err := parseReader(item)
+		if err != nil {
+			return nil, err
+		}
+		for range count {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
+func parseReader(spec string) (ReaderProfile, error) {
+	p := ReaderProfile{Name: spec}
+	kind, arg, _ := strings.Cut(spec, "=")
+	p.Kind = kind
+	var err error
+	switch kind {
+	case ReaderDefault, ReaderFast:
+		if arg == "" {
+			return p, nil
+		}
+	case ReaderBandwidth, ReaderAbandon:
+		if p.Bytes, err = parseSize(arg); err == nil && p.Bytes > 0 {
+			return p, nil
+		}
+	case ReaderStall:
+		size, d, _ := strings.Cut(arg, "/")
+		if p.Bytes, err = parseSize(size); err == nil {
+			if p.Stall, err = time.ParseDuration(d); err == nil && p.Stall > 0 {
+				return p, nil
+			}
+		}
+	}
+	return p, fmt.Errorf("invalid -readers profile %q, use default, fast, bandwidth=<size>, stall=<size>/<duration> or abandon=<size>", spec)
+}
+
+// parseSize parses a byte size of an optional unit B, KB, MB or GB, e.g. 64KB.
+func parseSize(str string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(str))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		n      int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = v, u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", str)
+	}
+	return n * unit, nil
+}
+
+// errAbandoned is returned by the reader of the abandon profile when it stops reading.
+var errAbandoned = errors.New("abandoned")
+
+// Reader returns the reader of the body by the profile,
+// the reader of abandon returns errAbandoned after the size.
+func (p ReaderProfile) Reader(r io.Reader) io.Reader {
+	switch p.Kind {
+	case ReaderFast:
+		return r
+	case ReaderBandwidth:
+		return &bandwidthReader{r: r, bytesPerSec: p.Bytes}
+	case ReaderStall:
+		return &stallReader{r: r, after: p.Bytes, stall: p.Stall}
+	case ReaderAbandon:
+		return &abandonReader{r: r, left: p.Bytes}
+	}
+	bufSize := readBuffSize
+	if bufSize <= 0 {
+		bufSize = 512
+	}
+	return &pacedReader{r: r, bufSize: bufSize, delay: readSleep}
+}
+
+// pacedReader reads at most bufSize at a time and sleeps delay after every read.
+type pacedReader struct {
+	r       io.Reader
+	bufSize int
+	delay   time.Duration
+}
+
+func (pr *pacedReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b[:min(len(b), pr.bufSize)])
+	if err == nil && pr.delay > 0 {
+		time.Sleep(pr.delay)
+	}
+	return n, err
+}
+
+// bandwidthReader reads at most bytesPerSec per second, in chunks of about 1/10 second.
+type bandwidthReader struct {
+	r           io.Reader
+	bytesPerSec int64
+	start       time.Time
+	read        int64
+}
+
+func (br *bandwidthReader) Read(b []byte) (int, error) {
+	if br.start.IsZero() {
+		br.start = time.Now()
+	}
+	n, err := br.r.Read(b[:min(int64(len(b)), max(br.bytesPerSec/10, 1))])
+	br.read += int64(n)
+	due := time.Duration(float64(br.read) / float64(br.bytesPerSec) * float64(time.Second))
+	if wait := due - time.Since(br.start); wait > 0 && err == nil {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// stallReader stalls once after reading the size.
+type stallReader struct {
+	r       io.Reader
+	after   int64
+	stall   time.Duration
+	read    int64
+	stalled bool
+}
+
+func (sr *stallReader) Read(b []byte) (int, error) {
+	if !sr.stalled && sr.read >= sr.after {
+		sr.stalled = true
+		time.Sleep(sr.stall)
+	}
+	if !sr.stalled {
+		b = b[:min(int64(len(b)), sr.after-sr.read)]
+	}
+	n, err := sr.r.Read(b)
+	sr.read += int64(n)
+	return n, err
+}
+
+// abandonReader returns errAbandoned after the size.
+type abandonReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (ar *abandonReader) Read(b []byte) (int, error) {
+	if ar.left <= 0 {
+		return 0, errAbandoned
+	}
+	n, err := ar.r.Read(b[:min(int64(len(b)), ar.left)])
+	ar.left -= int64(n)
+	return n, err
+}
@@ -1,22 +1,4 @@
-package main
-
-func init() {
-	scenarios["multi"] = multiQueries
-	scenarios["simple"] = simpleQueries
-	scenarios["fake"] = []string{"@fake"}
-	scenarios["simple.tql"] = []string{"/db/tql/simple.tql"}
-}
-
-var simpleQueries = []string{
-	`select * from tag where meta1 = 'm1-0001' limit 1000`,
-	`select * from tag where meta1 = 'm1-0002' limit 1000`,
-	`select * from tag where meta1 = 'm1-0003' limit 1000`,
-	`select * from tag where meta1 = 'm1-0004' limit 1000`,
-	`select * from tag where meta1 = 'm1-0005' limit 1000`,
-}
-
-var multiQueries = []string{
-	`select count(*) from (select * from tag where meta1 in (
+select count(*) from (select * from tag where meta1 in (
 'm1-0001', 
 'm1-0002', 
 'm1-0003', 
@@ -47,8 +29,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-0202', 
 'm2-0203', 
 'm2-0204', 
-'m2-0205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-0205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0006', 
 'm1-0007', 
 'm1-0008', 
@@ -80,8 +62,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-0453', 
 'm2-0454', 
 'm2-0455'))
-`,
-	`select count(*) from (select * from tag where meta1 in (
+;
+select count(*) from (select * from tag where meta1 in (
 'm1-0011', 
 'm1-0012', 
 'm1-0013', 
@@ -112,8 +94,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-0702', 
 'm2-0703', 
 'm2-0704', 
-'m2-0705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-0705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0016', 
 'm1-0017', 
 'm1-0018', 
@@ -144,8 +126,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-0952', 
 'm2-0953', 
 'm2-0954', 
-'m2-0955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-0955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0021', 
 'm1-0022', 
 'm1-0023', 
@@ -176,8 +158,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-1202', 
 'm2-1203', 
 'm2-1204', 
-'m2-1205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-1205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0026', 
 'm1-0027', 
 'm1-0028', 
@@ -208,8 +190,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-1452', 
 'm2-1453', 
 'm2-1454', 
-'m2-1455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-1455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0031', 
 'm1-0032', 
 'm1-0033', 
@@ -240,8 +222,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-1702', 
 'm2-1703', 
 'm2-1704', 
-'m2-1705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-1705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0036', 
 'm1-0037', 
 'm1-0038', 
@@ -272,8 +254,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-1952', 
 'm2-1953', 
 'm2-1954', 
-'m2-1955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-1955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0041', 
 'm1-0042', 
 'm1-0043', 
@@ -304,8 +286,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-2202', 
 'm2-2203', 
 'm2-2204', 
-'m2-2205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-2205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0046', 
 'm1-0047', 
 'm1-0048', 
@@ -336,8 +318,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-2452', 
 'm2-2453', 
 'm2-2454', 
-'m2-2455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-2455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0051', 
 'm1-0052', 
 'm1-0053', 
@@ -368,8 +350,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-2702', 
 'm2-2703', 
 'm2-2704', 
-'m2-2705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-2705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0056', 
 'm1-0057', 
 'm1-0058', 
@@ -400,8 +382,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-2952', 
 'm2-2953', 
 'm2-2954', 
-'m2-2955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-2955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0061', 
 'm1-0062', 
 'm1-0063', 
@@ -432,8 +414,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-3202', 
 'm2-3203', 
 'm2-3204', 
-'m2-3205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-3205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0066', 
 'm1-0067', 
 'm1-0068', 
@@ -464,8 +446,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-3452', 
 'm2-3453', 
 'm2-3454', 
-'m2-3455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-3455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0071', 
 'm1-0072', 
 'm1-0073', 
@@ -496,8 +478,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-3702', 
 'm2-3703', 
 'm2-3704', 
-'m2-3705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-3705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0076', 
 'm1-0077', 
 'm1-0078', 
@@ -528,8 +510,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-3952', 
 'm2-3953', 
 'm2-3954', 
-'m2-3955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-3955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0081', 
 'm1-0082', 
 'm1-0083', 
@@ -560,8 +542,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-4202', 
 'm2-4203', 
 'm2-4204', 
-'m2-4205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-4205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0086', 
 'm1-0087', 
 'm1-0088', 
@@ -592,8 +574,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-4452', 
 'm2-4453', 
 'm2-4454', 
-'m2-4455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-4455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0091', 
 'm1-0092', 
 'm1-0093', 
@@ -624,8 +606,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-4702', 
 'm2-4703', 
 'm2-4704', 
-'m2-4705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-4705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0096', 
 'm1-0097', 
 'm1-0098', 
@@ -656,8 +638,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-4952', 
 'm2-4953', 
 'm2-4954', 
-'m2-4955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-4955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0101', 
 'm1-0102', 
 'm1-0103', 
@@ -688,8 +670,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-5202', 
 'm2-5203', 
 'm2-5204', 
-'m2-5205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-5205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0106', 
 'm1-0107', 
 'm1-0108', 
@@ -720,8 +702,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-5452', 
 'm2-5453', 
 'm2-5454', 
-'m2-5455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-5455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0111', 
 'm1-0112', 
 'm1-0113', 
@@ -752,8 +734,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-5702', 
 'm2-5703', 
 'm2-5704', 
-'m2-5705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-5705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0116', 
 'm1-0117', 
 'm1-0118', 
@@ -784,8 +766,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-5952', 
 'm2-5953', 
 'm2-5954', 
-'m2-5955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-5955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0121', 
 'm1-0122', 
 'm1-0123', 
@@ -816,8 +798,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-6202', 
 'm2-6203', 
 'm2-6204', 
-'m2-6205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-6205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0126', 
 'm1-0127', 
 'm1-0128', 
@@ -848,8 +830,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-6452', 
 'm2-6453', 
 'm2-6454', 
-'m2-6455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-6455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0131', 
 'm1-0132', 
 'm1-0133', 
@@ -880,8 +862,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-6702', 
 'm2-6703', 
 'm2-6704', 
-'m2-6705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-6705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0136', 
 'm1-0137', 
 'm1-0138', 
@@ -912,8 +894,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-6952', 
 'm2-6953', 
 'm2-6954', 
-'m2-6955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-6955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0141', 
 'm1-0142', 
 'm1-0143', 
@@ -944,8 +926,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-7202', 
 'm2-7203', 
 'm2-7204', 
-'m2-7205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-7205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0146', 
 'm1-0147', 
 'm1-0148', 
@@ -976,8 +958,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-7452', 
 'm2-7453', 
 'm2-7454', 
-'m2-7455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-7455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0151', 
 'm1-0152', 
 'm1-0153', 
@@ -1008,8 +990,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-7702', 
 'm2-7703', 
 'm2-7704', 
-'m2-7705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-7705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0156', 
 'm1-0157', 
 'm1-0158', 
@@ -1040,8 +1022,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-7952', 
 'm2-7953', 
 'm2-7954', 
-'m2-7955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-7955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0161', 
 'm1-0162', 
 'm1-0163', 
@@ -1072,8 +1054,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-8202', 
 'm2-8203', 
 'm2-8204', 
-'m2-8205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-8205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0166', 
 'm1-0167', 
 'm1-0168', 
@@ -1104,8 +1086,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-8452', 
 'm2-8453', 
 'm2-8454', 
-'m2-8455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-8455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0171', 
 'm1-0172', 
 'm1-0173', 
@@ -1136,8 +1118,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-8702', 
 'm2-8703', 
 'm2-8704', 
-'m2-8705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-8705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0176', 
 'm1-0177', 
 'm1-0178', 
@@ -1168,8 +1150,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-8952', 
 'm2-8953', 
 'm2-8954', 
-'m2-8955'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-8955'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0181', 
 'm1-0182', 
 'm1-0183', 
@@ -1200,8 +1182,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-9202', 
 'm2-9203', 
 'm2-9204', 
-'m2-9205'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-9205'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0186', 
 'm1-0187', 
 'm1-0188', 
@@ -1232,8 +1214,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-9452', 
 'm2-9453', 
 'm2-9454', 
-'m2-9455'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-9455'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0191', 
 'm1-0192', 
 'm1-0193', 
@@ -1264,8 +1246,8 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-9702', 
 'm2-9703', 
 'm2-9704', 
-'m2-9705'))`,
-	`select count(*) from (select * from tag where meta1 in (
+'m2-9705'));
+select count(*) from (select * from tag where meta1 in (
 'm1-0196', 
 'm1-0197', 
 'm1-0198', 
@@ -1296,5 +1278,4 @@ and time < to_date('2025-01-01 01:02:00') and meta2 in (
 'm2-9952', 
 'm2-9953', 
 'm2-9954', 
-'m2-9955'))`,
-}
+'m2-9955'));
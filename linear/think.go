@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Think time distributions of a worker between its requests.
+//
+//   - <duration>: fixed, e.g. 2s, 0 is none
+//   - uniform:<min>-<max>: uniform in [min, max), e.g. uniform:1s-5s
+//   - exp:<mean>: exponential of the mean, e.g. exp:3s
+const (
+	ThinkFixed   = "fixed"
+	ThinkUniform = "uniform"
+	ThinkExp     = "exp"
+)
+
+type ThinkTime struct {
+	Kind string
+	Min  time.Duration // the fixed time, the min of uniform or the mean of exp
+	Max  time.Duration
+}
+
+// ParseThinkTime parses the -think flag.
+func ParseThinkTime(str string) (ThinkTime, error) {
+	kind, arg, ok := strings.Cut(str, ":")
+	if !ok {
+		d, err := time.ParseDuration(str)
+		if err != nil || d < 0 {
+			return ThinkTime{}, fmt.Errorf("invalid -think %q, use <duration>, uniform:<min>-<max> or exp:<mean>", str)
+		}
+		return ThinkTime{Kind: ThinkFixed, Min: d}, nil
+	}
+	switch kind {
+	case ThinkUniform:
+		lo, hi, _ := strings.Cut(arg, "-")
+		from, err1 := time.ParseDuration(lo)
+		to, err2 := time.ParseDuration(hi)
+		if err1 == nil && err2 == nil && from >= 0 && to > from {
+			return ThinkTime{Kind: ThinkUniform, Min: from, Max: to}, nil
+		}
+	case ThinkExp:
+		mean, err := time.ParseDuration(arg)
+		if err == nil && mean > 0 {
+			return ThinkTime{Kind: ThinkExp, Min: mean}, nil
+		}
+	}
+	return ThinkTime{}, fmt.Errorf("invalid -think %q, use <duration>, uniform:<min>-<max> or exp:<mean>", str)
+}
+
+// Next returns the think time before the next request.
+func (t ThinkTime) Next() time.Duration {
+	switch t.Kind {
+	case ThinkUniform:
+		return t.Min + time.Duration(rand.Int63n(int64(t.Max-t.Min)))
+	case ThinkExp:
+		return time.Duration(rand.ExpFloat64() * float64(t.Min))
+	}
+	return t.Min
+}
+
+func (t ThinkTime) String() string {
+	switch t.Kind {
+	case ThinkUniform:
+		return fmt.Sprintf("uniform %v - %v", t.Min, t.Max)
+	case ThinkExp:
+		return fmt.Sprintf("exponential mean %v", t.Min)
+	}
+	return t.Min.String()
+}
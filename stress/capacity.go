@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SLO is the service level objective of a capacity probe.
+//
+//   - P99: the p99 latency of the requests, 0 means no latency objective
+//   - ErrorRate: the requests failed after the retries over all requests
+//
+// A probe also fails if it achieves less than capacityMinAchieved of the
+// target rate or misses requests, the client or the server could not keep up.
+type SLO struct {
+	P99       time.Duration
+	ErrorRate float64
+}
+
+const capacityMinAchieved = 0.95
+
+func (slo SLO) String() string {
+	ret := []string{}
+	if slo.P99 > 0 {
+		ret = append(ret, fmt.Sprintf("p99 < %v", slo.P99))
+	}
+	ret = append(ret, fmt.Sprintf("error rate <= %g%%", slo.ErrorRate*100))
+	return strings.Join(ret, ", ")
+}
+
+// CapacityProbe is the evidence of a probe run at a constant rate.
+type CapacityProbe struct {
+	Rate      float64 // target requests per second
+	Achieved  float64 // achieved requests per second
+	P99       time.Duration
+	ErrorRate float64
+	Missed    int64
+	Pass      bool
+	Reason    string // why the probe failed
+	Result    *Result
+}
+
+// Check evaluates the summary of a probe of the op against the SLO.
+func (slo SLO) Check(op string, rate float64, r *Result) CapacityProbe {
+	p := CapacityProbe{Rate: rate, Result: r}
+	// the requests failed after the retries, a retried request is counted once
+	var count, errs int64
+	var p99 float64
+	if op == "append" {
+		count, errs, p99, p.Missed = r.Append.Count, r.Append.Errors, r.Append.Http.P99, r.Append.Missed
+	} else {
+		count, errs, p99, p.Missed = r.Select.Count, r.Select.Errors, r.Select.Http.P99, r.Select.Missed
+	}
+	if r.DurationSec > 0 {
+		p.Achieved = float64(count+errs) / r.DurationSec
+	}
+	if count+errs > 0 {
+		p.ErrorRate = float64(errs) / float64(count+errs)
+	}
+	p.P99 = time.Duration(p99 * float64(time.Millisecond))
+	reasons := []string{}
+	if slo.P99 > 0 && p.P99 >= slo.P99 {
+		reasons = append(reasons, fmt.Sprintf("p99 %v >= %v", p.P99.Round(time.Microsecond), slo.P99))
+	}
+	if p.ErrorRate > slo.ErrorRate {
+		reasons = append(reasons, fmt.Sprintf("error rate %.2f%% > %g%%", p.ErrorRate*100, slo.ErrorRate*100))
+	}
+	if p.Achieved < rate*capacityMinAchieved {
+		reasons = append(reasons, fmt.Sprintf("achieved %.1f/s < %.0f%% of target", p.Achieved, capacityMinAchieved*100))
+	}
+	if p.Missed > 0 {
+		reasons = append(reasons, fmt.Sprintf("missed %d", p.Missed))
+	}
+	p.Pass, p.Reason = len(reasons) == 0, strings.Join(reasons, ", ")
+	return p
+}
+
+// CapacitySearch finds the highest rate of append or select requests that
+// passes the SLO. The rate doubles from Min until a probe fails,
+// then it is bisected between the highest pass and the lowest failure.
+type CapacitySearch struct {
+	Op            string // "append" or "select"
+	SLO           SLO
+	ProbeDuration time.Duration
+	Min           float64 // rate of the first probe
+	Max           float64 // upper bound of the rate, 0 means no bound
+	Precision     float64 // the search stops when the gap is within Precision of the failed rate
+	MaxProbes     int
+}
+
+// ParseCapacityOp parses the -find-capacity flag.
+func ParseCapacityOp(str string) (string, error) {
+	switch str {
+	case "append", "select":
+		return str, nil
+	}
+	return "", fmt.Errorf("invalid -find-capacity %q, use append or select", str)
+}
+
+// Next returns the rate of the next probe,
+// or false if the search is over.
+func (cs CapacitySearch) Next(probes []CapacityProbe) (float64, bool) {
+	if len(probes) >= cs.MaxProbes {
+		return 0, false
+	}
+	if len(probes) == 0 {
+		return cs.Min, true
+	}
+	pass, fail := cs.bounds(probes)
+	if fail == 0 {
+		if cs.Max > 0 && pass >= cs.Max {
+			return 0, false
+		}
+		next := pass * 2
+		if cs.Max > 0 {
+			next = min(next, cs.Max)
+		}
+		return next, true
+	}
+	if pass == 0 && fail <= cs.Min {
+		// even the lowest rate fails
+		return 0, false
+	}
+	if fail-pass <= fail*cs.Precision {
+		return 0, false
+	}
+	return (pass + fail) / 2, true
+}
+
+// bounds returns the highest passed rate below the lowest failed rate,
+// and the lowest failed rate, 0 if not found.
+func (cs CapacitySearch) bounds(probes []CapacityProbe) (float64, float64) {
+	var pass, fail float64
+	for _, p := range probes {
+		if !p.Pass && (fail == 0 || p.Rate < fail) {
+			fail = p.Rate
+		}
+	}
+	for _, p := range probes {
+		if p.Pass && p.Rate > pass && (fail == 0 || p.Rate < fail) {
+			pass = p.Rate
+		}
+	}
+	return pass, fail
+}
+
+// FindCapacity runs the probes of the search, every probe is a run of the
+// scenario of a single stage at the probe rate. The other op keeps the
+// rate of the scenario workers. newPolicy returns the error policy of a probe.
+// The probes so far are returned with ErrInterrupted if the search is interrupted.
+func (s Scenario) FindCapacity(cs CapacitySearch, opts RunOptions, newPolicy func() *ErrorPolicy) ([]CapacityProbe, error) {
+	appendRate := float64(s.AppendWorker * s.AppendWorkerRunPerSecond * appendSplit)
+	selectRate := float64(s.SelectWorker * s.SelectWorkerRunPerSecond)
+	probes := []CapacityProbe{}
+	for {
+		rate, ok := cs.Next(probes)
+		if !ok {
+			break
+		}
+		probe := s
+		st := Stage{
+			Name:       fmt.Sprintf("probe-%d", len(probes)+1),
+			Duration:   cs.ProbeDuration,
+			Shape:      StageStep,
+			AppendRate: appendRate,
+			SelectRate: selectRate,
+		}
+		if cs.Op == "append" {
+			st.AppendRate = rate
+		} else {
+			st.SelectRate = rate
+		}
+		probe.Stages = StageProfile{st}
+		probe.Timeout = cs.ProbeDuration
+		probeOpts := opts
+		probeOpts.ErrorPolicy = newPolicy()
+		// the table is dropped at most once before the first probe and after the last
+		probeOpts.CleanStart = opts.CleanStart && len(probes) == 0
+		probeOpts.CleanStop = false
+		printer.Printf("Probe %d: %s %.1f/s for %v\n\n", len(probes)+1, cs.Op, rate, cs.ProbeDuration)
+		result, err := probe.Run(probeOpts)
+		if errors.Is(err, ErrInterrupted) || result == nil {
+			return probes, err
+		}
+		p := cs.SLO.Check(cs.Op, rate, result)
+		if err != nil {
+			p.Pass = false
+			p.Reason = strings.TrimPrefix(p.Reason+", aborted: "+err.Error(), ", ")
+		}
+		probes = append(probes, p)
+		if p.Pass {
+			printer.Printf("Probe %d: %s %.1f/s pass\n\n", len(probes), cs.Op, rate)
+		} else {
+			printer.Printf("Probe %d: %s %.1f/s fail: %s\n\n", len(probes), cs.Op, rate, p.Reason)
+		}
+	}
+	if opts.CleanStop {
+		s.DropTableOnce(opts)
+	}
+	return probes, nil
+}
+
+// PrintCapacity prints the evidence of every probe and the highest passed rate.
+func PrintCapacity(cs CapacitySearch, probes []CapacityProbe, recordsPerRequest int) {
+	printer.Printf("Capacity search of %s, SLO: %s\n", cs.Op, cs.SLO)
+	printer.Printf("%-6s %10s %10s %12s %10s %8s %6s  %s\n",
+		"Probe", "target/s", "achieved/s", "p99", "errors", "missed", "result", "reason")
+	for i, p := range probes {
+		verdict := "pass"
+		if !p.Pass {
+			verdict = "fail"
+		}
+		printer.Printf("%-6d %10.1f %10.1f %12v %9.2f%% %8d %6s  %s\n",
+			i+1, p.Rate, p.Achieved, p.P99.Round(time.Microsecond), p.ErrorRate*100, p.Missed, verdict, p.Reason)
+	}
+	pass, fail := cs.bounds(probes)
+	switch {
+	case pass == 0:
+		printer.Printf("Capacity: no probe passed the SLO\n")
+	case cs.Op == "append":
+		printer.Printf("Capacity: append %.1f/s (%.1f records/s)", pass, pass*float64(recordsPerRequest))
+	default:
+		printer.Printf("Capacity: select %.1f/s", pass)
+	}
+	if pass > 0 {
+		if fail > 0 {
+			printer.Printf(", fails at %.1f/s\n", fail)
+		} else {
+			printer.Printf(", no failure up to the highest probe\n")
+		}
+	}
+	printer.Println()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+// Endpoint policies assign a request to one of the http endpoints.
+//
+//   - round-robin: every request goes to the next endpoint
+//   - random: every request goes to a random endpoint, drawn from the RNG of the request
+//   - sticky: every worker stays on an endpoint, worker id modulo the endpoint count
+const (
+	EndpointRoundRobin = "round-robin"
+	EndpointRandom     = "random"
+	EndpointSticky     = "sticky"
+)
+
+// ParseEndpoints parses the -neo-http flag, a comma separated list of http addresses.
+func ParseEndpoints(str string) ([]string, error) {
+	ret := []string{}
+	for _, addr := range strings.Split(str, ",") {
+		addr = strings.TrimSuffix(strings.TrimSpace(addr), "/")
+		if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+			return nil, fmt.Errorf("invalid -neo-http %q, use http://host:port", addr)
+		}
+		ret = append(ret, addr)
+	}
+	return ret, nil
+}
+
+func ParseEndpointPolicy(str string) (string, error) {
+	switch str {
+	case EndpointRoundRobin, EndpointRandom, EndpointSticky:
+		return str, nil
+	}
+	return "", fmt.Errorf("invalid -endpoint-policy %q, use round-robin, random or sticky", str)
+}
+
+// EndpointPicker picks the index of the endpoint of a request by the policy.
+type EndpointPicker struct {
+	Policy string
+	N      int
+	next   atomic.Uint64
+}
+
+func NewEndpointPicker(policy string, n int) *EndpointPicker {
+	return &EndpointPicker{Policy: policy, N: max(n, 1)}
+}
+
+// Pick returns the endpoint of a request of the worker,
+// rng is the RNG of the request, so that random is reproducible by -seed.
+func (p *EndpointPicker) Pick(rng *rand.Rand, workerId int) int {
+	if p.N == 1 {
+		return 0
+	}
+	switch p.Policy {
+	case EndpointRandom:
+		return rng.Intn(p.N)
+	case EndpointSticky:
+		return workerId % p.N
+	}
+	return int((p.next.Add(1) - 1) % uint64(p.N))
+}
+
+// httpEndpoints returns the http endpoints of the run,
+// the NeoHttpAddr if the endpoints are not given.
+func (opts RunOptions) httpEndpoints() []string {
+	if len(opts.Endpoints) == 0 {
+		return []string{opts.NeoHttpAddr}
+	}
+	return opts.Endpoints
+}
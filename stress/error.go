@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// RequestError is a failed append or select request, classified by Category
+// for the error report.
+//
+// Categories:
+//   - dial: connection could not be established
+//   - timeout: http timeout or deadline exceeded
+//   - canceled: in-flight request canceled at the end of the drain timeout
+//   - transport: other errors while sending the request
+//   - read: failed to read the response body
+//   - http <status>: the server answered with a non-200 status
+//   - reason: <reason>: the server answered success:false
+//   - parse: the response could not be parsed
+//   - encode: the payload of an append could not be encoded, it is not sent
+type RequestError struct {
+	Category string
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	return e.Category + ": " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func transportError(err error) *RequestError {
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &RequestError{Category: "canceled", Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &RequestError{Category: "timeout", Err: err}
+	case errors.Is(err, syscall.ECONNREFUSED), errors.As(err, &opErr) && opErr.Op == "dial":
+		return &RequestError{Category: "dial", Err: err}
+	}
+	return &RequestError{Category: "transport", Err: err}
+}
+
+func statusError(rsp *http.Response, body []byte) *RequestError {
+	return &RequestError{
+		Category: "http " + strconv.Itoa(rsp.StatusCode),
+		Err:      fmt.Errorf("%s %s", rsp.Status, strings.TrimSpace(string(body))),
+	}
+}
+
+func reasonError(reason string) *RequestError {
+	category := reason
+	// strip the variable part, e.g. "... at line 12" or numbers
+	if i := strings.IndexAny(category, "0123456789"); i > 0 {
+		category = strings.TrimSpace(category[:i])
+	}
+	if len(category) > 60 {
+		category = category[:60]
+	}
+	return &RequestError{Category: "reason: " + category, Err: errors.New(reason)}
+}
+
+// ErrorPolicy decides what happens when a request fails.
+//
+//   - abort: stop the run at the first error
+//   - count: count the error and continue
+//   - retry: retry with exponential backoff, then count the error
+//
+// MaxErrorRate is the error budget, the run is stopped when
+// the ratio of failed requests exceeds it.
+type ErrorPolicy struct {
+	Mode         string
+	MaxRetry     int
+	Backoff      time.Duration
+	MaxErrorRate float64
+
+	requests     int64
+	errors       int64
+	reportedOnce sync.Map
+}
+
+const (
+	OnErrorAbort = "abort"
+	OnErrorCount = "count"
+	OnErrorRetry = "retry"
+)
+
+// minimum number of requests before the error budget is enforced.
+const errorBudgetMinRequests = 100
+
+func ParseErrorPolicy(mode string, maxRetry int, backoff time.Duration, maxErrorRate string) (*ErrorPolicy, error) {
+	p := &ErrorPolicy{Mode: mode, MaxRetry: maxRetry, Backoff: backoff}
+	switch mode {
+	case OnErrorAbort, OnErrorCount, OnErrorRetry:
+	default:
+		return nil, fmt.Errorf("invalid -on-error %q, use abort, count or retry", mode)
+	}
+	if maxErrorRate != "" {
+		rate, err := parseRate(maxErrorRate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -max-error-rate %q", maxErrorRate)
+		}
+		p.MaxErrorRate = rate
+	}
+	return p, nil
+}
+
+// parseRate parses "1%" or "0.01".
+func parseRate(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return v / 100, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Do calls fn and applies the policy on its error.
+// It returns whether the run should be stopped and the last error of fn.
+func (p *ErrorPolicy) Do(closeCh <-chan struct{}, fn func() error) (bool, error) {
+	atomic.AddInt64(&p.requests, 1)
+	err := fn()
+	if err != nil && p.Mode == OnErrorRetry {
+		backoff := p.Backoff
+		for retry := 0; err != nil && retry < p.MaxRetry; retry++ {
+			select {
+			case <-closeCh:
+				return false, err
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, 5*time.Second)
+			err = fn()
+		}
+	}
+	if err == nil {
+		return false, nil
+	}
+	return p.fail(err)
+}
+
+// Fail counts a request that failed before it could be sent, e.g. an encode error,
+// it is not retried.
+func (p *ErrorPolicy) Fail(err error) (bool, error) {
+	atomic.AddInt64(&p.requests, 1)
+	return p.fail(err)
+}
+
+// fail counts the error of a request and checks the error budget.
+func (p *ErrorPolicy) fail(err error) (bool, error) {
+	errCount := atomic.AddInt64(&p.errors, 1)
+	if p.Mode == OnErrorAbort {
+		return true, err
+	}
+	if p.MaxErrorRate > 0 {
+		reqCount := atomic.LoadInt64(&p.requests)
+		if reqCount >= errorBudgetMinRequests && float64(errCount)/float64(reqCount) > p.MaxErrorRate {
+			return true, fmt.Errorf("error rate %.2f%% exceeds budget %.2f%%, last error %w",
+				float64(errCount)*100/float64(reqCount), p.MaxErrorRate*100, err)
+		}
+	}
+	return false, err
+}
+
+// Report prints the error with detail at the first occurrence of its category,
+// or every time in abort mode.
+func (p *ErrorPolicy) Report(op string, err error) {
+	category := errorCategory(err)
+	if _, loaded := p.reportedOnce.LoadOrStore(op+"/"+category, true); loaded && p.Mode != OnErrorAbort {
+		return
+	}
+	fmt.Printf("Failed to %s data: %v\n", op, err)
+}
+
+func errorCategory(err error) string {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Category
+	}
+	return "unknown"
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Env holds the variables visible to expressions and templates
+// while a record or a query is being generated.
+type Env struct {
+	vars map[string]int64
+	rng  *rand.Rand // nil is the global math/rand
+}
+
+func NewEnv() *Env {
+	return &Env{vars: map[string]int64{}}
+}
+
+// NewEnvRand returns an Env whose random functions draw from rng, nil is the global math/rand.
+func NewEnvRand(rng *rand.Rand) *Env {
+	return &Env{vars: map[string]int64{}, rng: rng}
+}
+
+func (env *Env) Rand() *rand.Rand {
+	if env.rng == nil {
+		return globalRand
+	}
+	return env.rng
+}
+
+func (env *Env) Set(name string, value int64) {
+	env.vars[name] = value
+}
+
+func (env *Env) Get(name string) (int64, bool) {
+	v, ok := env.vars[name]
+	return v, ok
+}
+
+// Expr is a compiled integer expression.
+//
+// Supported syntax:
+//   - integer literals (1_000_000) and duration literals (10s, 2m, 500ms) in nanoseconds
+//   - variables: now, worker, run, nth, cpu and user defined vars
+//   - operators: + - * / % == != < <= > >= && || ! and parentheses
+//   - functions: rand(n), if(cond, a, b), min(a, b), max(a, b), abs(a)
+//   - generators (percentages are integers of 0 to 100):
+//     zipf(n, skew) a key in [0, n) of zipfian popularity, skew is the exponent times 100, e.g. 120;
+//     hot(n, keys, traffic) a key in [0, n), traffic% of them in the first keys% of the keys;
+//     late(pct, d) -d for pct% of the calls, a timestamp offset of late arrivals;
+//     disorder(pct, d) a random offset in (-d, 0] for pct% of the calls, out of order arrivals;
+//     dup(pct, unit) the offset truncating now to the unit for pct% of the calls, duplicate timestamps;
+//     burst(on, off, phase) 1 for on and 0 for off of every period of now+phase, bursty sources
+//
+// Comparison and logical operators yield 1 for true and 0 for false.
+type Expr interface {
+	Eval(env *Env) int64
+}
+
+type exprFunc func(env *Env) int64
+
+func (f exprFunc) Eval(env *Env) int64 { return f(env) }
+
+func ParseExpr(src string) (Expr, error) {
+	p := &exprParser{src: src}
+	p.next()
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.tok.text, src)
+	}
+	return e, nil
+}
+
+func MustParseExpr(src string) Expr {
+	e, err := ParseExpr(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value int64
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok token
+	err error
+}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF}
+		return
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9':
+		for p.pos < len(p.src) && isLiteralChar(p.src[p.pos]) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		p.tok = token{kind: tokNumber, text: text}
+		lit := strings.ReplaceAll(text, "_", "")
+		if v, err := strconv.ParseInt(lit, 10, 64); err == nil {
+			p.tok.value = v
+		} else if d, err := time.ParseDuration(lit); err == nil {
+			p.tok.value = int64(d)
+		} else if p.err == nil {
+			p.err = fmt.Errorf("invalid number %q", text)
+		}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos]}
+	default:
+		two := ""
+		if p.pos+1 < len(p.src) {
+			two = p.src[p.pos : p.pos+2]
+		}
+		switch two {
+		case "==", "!=", "<=", ">=", "&&", "||":
+			p.pos += 2
+			p.tok = token{kind: tokOp, text: two}
+			return
+		}
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c)}
+	}
+}
+
+func isLiteralChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	return p.parseBinary(p.parseCompare, "&&")
+}
+
+func (p *exprParser) parseCompare() (Expr, error) {
+	return p.parseBinary(p.parseAdd, "==", "!=", "<", "<=", ">", ">=")
+}
+
+func (p *exprParser) parseAdd() (Expr, error) {
+	return p.parseBinary(p.parseMul, "+", "-")
+}
+
+func (p *exprParser) parseMul() (Expr, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseBinary(operand func() (Expr, error), ops ...string) (Expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops...) {
+		op := p.tok.text
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr(op, left, right)
+	}
+	return left, nil
+}
+
+func binaryExpr(op string, l, r Expr) Expr {
+	switch op {
+	case "+":
+		return exprFunc(func(env *Env) int64 { return l.Eval(env) + r.Eval(env) })
+	case "-":
+		return exprFunc(func(env *Env) int64 { return l.Eval(env) - r.Eval(env) })
+	case "*":
+		return exprFunc(func(env *Env) int64 { return l.Eval(env) * r.Eval(env) })
+	case "/":
+		return exprFunc(func(env *Env) int64 {
+			if d := r.Eval(env); d != 0 {
+				return l.Eval(env) / d
+			}
+			return 0
+		})
+	case "%":
+		return exprFunc(func(env *Env) int64 {
+			if d := r.Eval(env); d != 0 {
+				return l.Eval(env) % d
+			}
+			return 0
+		})
+	case "==":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) == r.Eval(env)) })
+	case "!=":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) != r.Eval(env)) })
+	case "<":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) < r.Eval(env)) })
+	case "<=":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) <= r.Eval(env)) })
+	case ">":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) > r.Eval(env)) })
+	case ">=":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) >= r.Eval(env)) })
+	case "&&":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) != 0 && r.Eval(env) != 0) })
+	case "||":
+		return exprFunc(func(env *Env) int64 { return boolInt(l.Eval(env) != 0 || r.Eval(env) != 0) })
+	}
+	panic("unknown operator " + op)
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	if p.isOp("-") {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprFunc(func(env *Env) int64 { return -e.Eval(env) }), nil
+	}
+	if p.isOp("!") {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprFunc(func(env *Env) int64 { return boolInt(e.Eval(env) == 0) }), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch p.tok.kind {
+	case tokNumber:
+		v := p.tok.value
+		p.next()
+		return exprFunc(func(*Env) int64 { return v }), nil
+	case tokIdent:
+		name := p.tok.text
+		p.next()
+		if p.isOp("(") {
+			p.next()
+			args := []Expr{}
+			for !p.isOp(")") {
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.isOp(",") {
+					p.next()
+				} else if !p.isOp(")") {
+					return nil, fmt.Errorf("expected ',' or ')' in call of %s(), got %q", name, p.tok.text)
+				}
+			}
+			p.next()
+			return callExpr(name, args)
+		}
+		return exprFunc(func(env *Env) int64 {
+			v, _ := env.Get(name)
+			return v
+		}), nil
+	case tokOp:
+		if p.tok.text == "(" {
+			p.next()
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, fmt.Errorf("expected ')', got %q", p.tok.text)
+			}
+			p.next()
+			return e, nil
+		}
+	}
+	if p.tok.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	}
+	return nil, fmt.Errorf("unexpected %q in expression %q", p.tok.text, p.src)
+}
+
+type exprBuiltin struct {
+	nargs int
+	fn    func(env *Env, args []Expr) int64
+}
+
+var exprBuiltins = map[string]exprBuiltin{
+	"rand": {1, func(env *Env, args []Expr) int64 {
+		if n := args[0].Eval(env); n > 0 {
+			return env.Rand().Int63n(n)
+		}
+		return 0
+	}},
+	"if": {3, func(env *Env, args []Expr) int64 {
+		if args[0].Eval(env) != 0 {
+			return args[1].Eval(env)
+		}
+		return args[2].Eval(env)
+	}},
+	"min": {2, func(env *Env, args []Expr) int64 {
+		return min(args[0].Eval(env), args[1].Eval(env))
+	}},
+	"max": {2, func(env *Env, args []Expr) int64 {
+		return max(args[0].Eval(env), args[1].Eval(env))
+	}},
+	"abs": {1, func(env *Env, args []Expr) int64 {
+		if v := args[0].Eval(env); v < 0 {
+			return -v
+		} else {
+			return v
+		}
+	}},
+	"zipf": {2, func(env *Env, args []Expr) int64 {
+		return zipfKey(env.Rand(), args[0].Eval(env), args[1].Eval(env))
+	}},
+	"hot": {3, func(env *Env, args []Expr) int64 {
+		return hotKey(env.Rand(), args[0].Eval(env), args[1].Eval(env), args[2].Eval(env))
+	}},
+	"late": {2, func(env *Env, args []Expr) int64 {
+		return lateOffset(env.Rand(), args[0].Eval(env), args[1].Eval(env))
+	}},
+	"disorder": {2, func(env *Env, args []Expr) int64 {
+		return disorderOffset(env.Rand(), args[0].Eval(env), args[1].Eval(env))
+	}},
+	"dup": {2, func(env *Env, args []Expr) int64 {
+		now, _ := env.Get("now")
+		return dupOffset(env.Rand(), args[0].Eval(env), args[1].Eval(env), now)
+	}},
+	"burst": {3, func(env *Env, args []Expr) int64 {
+		now, _ := env.Get("now")
+		return burstActive(args[0].Eval(env), args[1].Eval(env), args[2].Eval(env), now)
+	}},
+}
+
+func callExpr(name string, args []Expr) (Expr, error) {
+	b, ok := exprBuiltins[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s()", name)
+	}
+	if len(args) != b.nargs {
+		return nil, fmt.Errorf("%s() takes %d argument(s), got %d", name, b.nargs, len(args))
+	}
+	return exprFunc(func(env *Env) int64 { return b.fn(env, args) }), nil
+}
+
+// Template is a text with {expr} placeholders.
+//
+// A placeholder may carry a format after '|':
+//   - {now-10s}            unix epoch nanoseconds
+//   - {now-2m|datetime}    local time as 'YYYY-MM-DD HH:MM:SS'
+//
+// A placeholder that names a list (see ListSpec) expands to the joined list.
+type Template struct {
+	parts []func(env *Env, w *strings.Builder)
+}
+
+func ParseTemplate(src string, lists map[string]*List) (*Template, error) {
+	t := &Template{}
+	for len(src) > 0 {
+		open := strings.IndexByte(src, '{')
+		if open < 0 {
+			t.addLiteral(src)
+			break
+		}
+		t.addLiteral(src[:open])
+		closing := strings.IndexByte(src[open:], '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in template %q", src)
+		}
+		placeholder := strings.TrimSpace(src[open+1 : open+closing])
+		src = src[open+closing+1:]
+
+		if list, ok := lists[placeholder]; ok {
+			t.parts = append(t.parts, func(env *Env, w *strings.Builder) { list.Write(env, w) })
+			continue
+		}
+		format := ""
+		if i := strings.LastIndexByte(placeholder, '|'); i > 0 && placeholder[i-1] != '|' {
+			format = strings.TrimSpace(placeholder[i+1:])
+			placeholder = placeholder[:i]
+		}
+		e, err := ParseExpr(placeholder)
+		if err != nil {
+			return nil, err
+		}
+		switch format {
+		case "":
+			t.parts = append(t.parts, func(env *Env, w *strings.Builder) {
+				w.WriteString(strconv.FormatInt(e.Eval(env), 10))
+			})
+		case "datetime":
+			t.parts = append(t.parts, func(env *Env, w *strings.Builder) {
+				w.WriteString(time.Unix(0, e.Eval(env)).In(time.Local).Format("2006-01-02 15:04:05"))
+			})
+		default:
+			return nil, fmt.Errorf("unknown placeholder format %q", format)
+		}
+	}
+	return t, nil
+}
+
+func (t *Template) addLiteral(s string) {
+	if s == "" {
+		return
+	}
+	t.parts = append(t.parts, func(_ *Env, w *strings.Builder) { w.WriteString(s) })
+}
+
+func (t *Template) Write(env *Env, w *strings.Builder) {
+	for _, part := range t.parts {
+		part(env, w)
+	}
+}
+
+func (t *Template) Execute(env *Env) string {
+	w := &strings.Builder{}
+	t.Write(env, w)
+	return w.String()
+}
+
+// List repeats an item template Count times, joined by Sep.
+type List struct {
+	Count int
+	Sep   string
+	Item  *Template
+}
+
+func (l *List) Write(env *Env, w *strings.Builder) {
+	for i := 0; i < l.Count; i++ {
+		if i > 0 {
+			w.WriteString(l.Sep)
+		}
+		l.Item.Write(env, w)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "math/rand"
+
+// Generators of realistic key and time distributions, they are the functions
+// zipf, hot, late, disorder, dup and burst of the expressions.
+// Percentages are integers of 0 to 100.
+
+// percent returns true for pct% of the calls.
+func percent(r *rand.Rand, pct int64) bool {
+	return r.Int63n(100) < pct
+}
+
+// hotKey returns a key in [0, n), traffic% of the picks are in the hot set,
+// the first keys% of the keys.
+func hotKey(r *rand.Rand, n int64, keys int64, traffic int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	hot := min(max(n*keys/100, 1), n)
+	if hot == n || percent(r, traffic) {
+		return r.Int63n(hot)
+	}
+	return hot + r.Int63n(n-hot)
+}
+
+// zipfKey returns a key in [0, n) of zipfian popularity, key 0 is the most popular.
+// skew is the exponent times 100, at least 101.
+func zipfKey(r *rand.Rand, n int64, skew int64) int64 {
+	if n <= 1 {
+		return 0
+	}
+	// rand.Zipf keeps no state but r, it is cheap to make for every key
+	z := rand.NewZipf(r, float64(max(skew, 101))/100, 1, uint64(n-1))
+	return int64(z.Uint64())
+}
+
+// lateOffset returns -d for pct% of the calls, otherwise 0.
+func lateOffset(r *rand.Rand, pct int64, d int64) int64 {
+	if percent(r, pct) {
+		return -d
+	}
+	return 0
+}
+
+// disorderOffset returns a random offset in (-d, 0] for pct% of the calls, otherwise 0.
+func disorderOffset(r *rand.Rand, pct int64, d int64) int64 {
+	if d > 0 && percent(r, pct) {
+		return -r.Int63n(d)
+	}
+	return 0
+}
+
+// dupOffset returns the offset that truncates now to the unit for pct% of the calls, otherwise 0.
+func dupOffset(r *rand.Rand, pct int64, unit int64, now int64) int64 {
+	if unit > 0 && percent(r, pct) {
+		return -(now % unit)
+	}
+	return 0
+}
+
+// burstActive returns 1 for on and then 0 for off of every on+off period of now+phase.
+func burstActive(on int64, off int64, phase int64, now int64) int64 {
+	if on <= 0 || off <= 0 {
+		return boolInt(on > 0)
+	}
+	t := (now + phase) % (on + off)
+	if t < 0 {
+		t += on + off
+	}
+	return boolInt(t < on)
+}
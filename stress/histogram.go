@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram records durations into log-linear buckets in the manner of
+// HdrHistogram. Values below histSubBuckets are exact, larger values are
+// kept with histSubBits of precision, which bounds the relative error of
+// a reported percentile to about 1.6%.
+type Histogram struct {
+	counts []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+const (
+	histSubBits    = 7
+	histSubBuckets = 1 << histSubBits
+	histHalf       = histSubBuckets / 2
+	histLen        = histSubBuckets + (64-histSubBits)*histHalf
+)
+
+// Percentiles reported by the stress tool.
+var Percentiles = []float64{50, 90, 99, 99.9}
+
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]int64, histLen)}
+}
+
+func histIndex(v int64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histSubBits
+	mantissa := int(v >> shift) // [histHalf, histSubBuckets)
+	return histSubBuckets + (shift-1)*histHalf + (mantissa - histHalf)
+}
+
+// histValue returns the middle of the value range of the bucket.
+func histValue(idx int) int64 {
+	if idx < histSubBuckets {
+		return int64(idx)
+	}
+	idx -= histSubBuckets
+	shift := idx/histHalf + 1
+	mantissa := int64(idx%histHalf + histHalf)
+	lo := mantissa << shift
+	return lo + (int64(1)<<shift)/2
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	h.counts[histIndex(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+}
+
+func (h *Histogram) Reset() {
+	clear(h.counts)
+	h.count, h.sum, h.min, h.max = 0, 0, 0, 0
+}
+
+func (h *Histogram) Count() int64       { return h.count }
+func (h *Histogram) Min() time.Duration { return time.Duration(h.min) }
+func (h *Histogram) Max() time.Duration { return time.Duration(h.max) }
+func (h *Histogram) Sum() time.Duration { return time.Duration(h.sum) }
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.count)
+}
+
+// Percentile returns the value at the given percentile (0-100).
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := histValue(i)
+			v = max(v, h.min)
+			v = min(v, h.max)
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LagProbe writes a marker record every Interval and polls the raw table and
+// the rollup of the marker until it is visible, the time from the append
+// response until then is the visibility lag of the path.
+//
+// Every marker has its own tag, so that a rollup bucket of the tag holds
+// only the marker.
+type LagProbe struct {
+	Target   *VerifyTarget
+	Interval time.Duration
+	Timeout  time.Duration // how long a marker is polled
+	Rollup   string        // unit of the rollup path, sec, min or hour, empty if no rollup
+
+	prefix  string
+	closeCh chan struct{}
+	ctx     context.Context // canceled by Stop after the drain timeout
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup // the marker writer
+	pending sync.WaitGroup // the pollers
+}
+
+// Lag paths
+const (
+	LagRaw    = "raw"
+	LagRollup = "rollup"
+)
+
+const (
+	lagRawPollMaxInterval    = 100 * time.Millisecond
+	lagRollupPollMaxInterval = time.Second
+)
+
+var rollupUnits = map[string]time.Duration{"sec": time.Second, "min": time.Minute, "hour": time.Hour}
+
+var rollupRe = regexp.MustCompile(`(?i)with\s+rollup\s*\(\s*(sec|min|hour)\s*\)`)
+
+// RollupUnit returns the unit of "WITH ROLLUP(unit)" of the create table SQL, empty if none.
+func RollupUnit(createTableSql string) string {
+	if m := rollupRe.FindStringSubmatch(createTableSql); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// ParseLagRollup parses the -lag-rollup flag, "auto" takes the unit of the scenario table.
+func ParseLagRollup(str string, createTableSql string) (string, error) {
+	switch str = strings.ToLower(str); str {
+	case "auto":
+		return RollupUnit(createTableSql), nil
+	case "none":
+		return "", nil
+	}
+	if _, ok := rollupUnits[str]; !ok {
+		return "", fmt.Errorf("invalid -lag-rollup %q, use auto, none, sec, min or hour", str)
+	}
+	return str, nil
+}
+
+func NewLagProbe(target *VerifyTarget, interval time.Duration, timeout time.Duration, rollup string) *LagProbe {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LagProbe{
+		Target:   target,
+		Interval: interval,
+		Timeout:  timeout,
+		Rollup:   rollup,
+		prefix:   fmt.Sprintf("lag_%d", time.Now().Unix()),
+		closeCh:  make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// LagSample is the outcome of a marker on a path,
+// Visible is false if it was not visible until the timeout.
+type LagSample struct {
+	Path    string
+	Delay   time.Duration
+	Visible bool
+}
+
+// Start writes the markers until Stop.
+func (lp *LagProbe) Start(s Scenario, client *http.Client, neoHttpAddr string, stat *Stat) {
+	lp.wg.Add(1)
+	go func() {
+		defer lp.wg.Done()
+		ticker := time.NewTicker(lp.Interval)
+		defer ticker.Stop()
+		for seq := 0; ; seq++ {
+			select {
+			case <-lp.closeCh:
+				return
+			case <-ticker.C:
+			}
+			lp.mark(s, client, neoHttpAddr, stat, seq)
+		}
+	}()
+}
+
+// Stop stops writing markers and waits for the marker being written and the markers
+// being polled up to the drain timeout, the requests in flight are canceled then and
+// the markers still not visible are not reported.
+func (lp *LagProbe) Stop(drainTimeout time.Duration) {
+	close(lp.closeCh)
+	timer := time.AfterFunc(drainTimeout, lp.cancel)
+	lp.wg.Wait()
+	lp.pending.Wait()
+	timer.Stop()
+	lp.cancel()
+}
+
+// mark appends a marker and polls its paths.
+func (lp *LagProbe) mark(s Scenario, client *http.Client, neoHttpAddr string, stat *Stat, seq int) {
+	vt := lp.Target
+	now := time.Now()
+	rec := s.AppendRecordDataFunc(nil, 0, now, 0, 0)
+	tag := fmt.Sprintf("%s_%d", lp.prefix, seq)
+	rec[vt.tagIdx] = tag
+	rec[vt.timeIdx] = now.UnixNano()
+	if vt.valueIdx >= 0 {
+		if _, ok := rec[vt.valueIdx].(int64); ok {
+			rec[vt.valueIdx] = int64(seq)
+		} else {
+			rec[vt.valueIdx] = float64(seq)
+		}
+	}
+	format := PayloadFormat{Name: PayloadCSV}
+	batch, err := format.Encode(s.AppendColumns, [][]any{rec})
+	if err == nil {
+		err = s.appendData(lp.ctx, client, neoHttpAddr, format, batch.Body)
+	}
+	if lp.ctx.Err() != nil {
+		return
+	} else if err != nil {
+		stat.AddError("lag", err)
+		return
+	}
+	acked := time.Now()
+	rawSql := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s = '%s' AND %s = %d",
+		vt.Table, vt.TagColumn, tag, vt.TimeColumn, now.UnixNano())
+	lp.poll(s, client, neoHttpAddr, stat, LagRaw, rawSql, lagRawPollMaxInterval, acked)
+	if lp.Rollup != "" && vt.ValueColumn != "" {
+		unit := rollupUnits[lp.Rollup]
+		from := now.Truncate(unit)
+		rollupSql := fmt.Sprintf("SELECT rollup('%s', 1, %s) mtime, count(%s) FROM %s WHERE %s = '%s' AND %s >= %d AND %s < %d GROUP BY mtime",
+			lp.Rollup, vt.TimeColumn, vt.ValueColumn, vt.Table, vt.TagColumn, tag,
+			vt.TimeColumn, from.UnixNano(), vt.TimeColumn, from.Add(unit).UnixNano())
+		lp.poll(s, client, neoHttpAddr, stat, LagRollup, rollupSql, lagRollupPollMaxInterval, acked)
+	}
+}
+
+// poll queries the path with backoff until the marker is visible.
+func (lp *LagProbe) poll(s Scenario, client *http.Client, neoHttpAddr string, stat *Stat, path string, sqlText string, maxInterval time.Duration, acked time.Time) {
+	lp.pending.Add(1)
+	go func() {
+		defer lp.pending.Done()
+		deadline := acked.Add(lp.Timeout)
+		interval := verifyPollMinInterval
+		for {
+			rsp, err := s.queryJSON(lp.ctx, client, neoHttpAddr, sqlText, nil)
+			if lp.ctx.Err() != nil {
+				return
+			} else if err != nil {
+				stat.AddError("lag", err)
+			} else if cols := rsp.Get("data.rows.0").Array(); len(cols) > 0 && cols[len(cols)-1].Int() > 0 {
+				// the count of the marker is the last column
+				stat.AddLag(LagSample{Path: path, Delay: time.Since(acked), Visible: true})
+				return
+			}
+			if time.Now().After(deadline) {
+				stat.AddLag(LagSample{Path: path})
+				return
+			}
+			select {
+			case <-lp.ctx.Done():
+				return
+			case <-time.After(min(interval, time.Until(deadline)+time.Millisecond)):
+			}
+			interval = min(interval*2, maxInterval)
+		}
+	}()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are the cumulative counters of a run exposed in the
+// Prometheus text format, so that a run can be charted live.
+//
+// The runs of a -transport or -append-format list have their own counters,
+// the series are labeled with the transport and the format of the run.
+//
+// All methods are no-op on a nil *Metrics.
+type Metrics struct {
+	mu       sync.Mutex
+	scenario string
+	stage    string
+	workers  map[string]int // op -> configured workers
+	runs     []*runMetrics  // in the order of the runs
+	run      *runMetrics    // the current run
+}
+
+type runMetrics struct {
+	transport string
+	format    string                      // empty for the native transport
+	active    map[string]int              // op -> in-flight requests
+	requests  map[string]int64            // op -> requests sent
+	rows      map[string]int64            // op -> appended records or selected rows
+	bytes     map[string]int64            // op -> payload bytes
+	errors    map[string]map[string]int64 // op -> category -> count
+	latency   map[string]*promHistogram   // op -> client measured latency
+	query     *promHistogram              // server reported select elapse
+}
+
+func newRunMetrics(transport string, format string) *runMetrics {
+	return &runMetrics{
+		transport: transport,
+		format:    format,
+		active:    map[string]int{},
+		requests:  map[string]int64{},
+		rows:      map[string]int64{},
+		bytes:     map[string]int64{},
+		errors:    map[string]map[string]int64{"append": {}, "select": {}},
+		latency:   map[string]*promHistogram{"append": newPromHistogram(), "select": newPromHistogram()},
+		query:     newPromHistogram(),
+	}
+}
+
+// labels are the labels of the series of the run.
+func (rm *runMetrics) labels() string {
+	return fmt.Sprintf("transport=\"%s\",format=\"%s\"", promEscape(rm.transport), promEscape(rm.format))
+}
+
+// promBuckets are the upper bounds of the latency buckets in seconds.
+var promBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type promHistogram struct {
+	counts []int64 // per bucket, not cumulative, the last one is +Inf
+	count  int64
+	sum    float64
+}
+
+func newPromHistogram() *promHistogram {
+	return &promHistogram{counts: make([]int64, len(promBuckets)+1)}
+}
+
+func (h *promHistogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.counts[sort.SearchFloat64s(promBuckets, v)]++
+	h.count++
+	h.sum += v
+}
+
+// NewMetrics returns the metrics of the scenario, SetRun starts the counters of a run.
+func NewMetrics(scenario string, appendWorkers int, selectWorkers int) *Metrics {
+	return &Metrics{
+		scenario: scenario,
+		workers:  map[string]int{"append": appendWorkers, "select": selectWorkers},
+	}
+}
+
+// SetRun switches the counters to those of the run of the transport and the format,
+// it should be called before the requests of the run. A run given again continues
+// its counters, e.g. the probes of -find-capacity.
+func (m *Metrics) SetRun(transport string, format string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rm := range m.runs {
+		if rm.transport == transport && rm.format == format {
+			m.run = rm
+			return
+		}
+	}
+	m.run = newRunMetrics(transport, format)
+	m.runs = append(m.runs, m.run)
+}
+
+// Begin marks a request of the op in flight, it should be followed by End.
+func (m *Metrics) Begin(op string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.run.active[op]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) End(op string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.run.active[op]--
+	m.mu.Unlock()
+}
+
+func (m *Metrics) SetStage(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.stage = name
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ObserveAppend(records int64, bytes int64, elapse time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rm := m.run
+	rm.requests["append"]++
+	rm.latency["append"].observe(elapse)
+	if err != nil {
+		rm.errors["append"][errorCategory(err)]++
+		return
+	}
+	rm.rows["append"] += records
+	rm.bytes["append"] += bytes
+}
+
+func (m *Metrics) ObserveSelect(rows int64, queryElapse time.Duration, elapse time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rm := m.run
+	rm.requests["select"]++
+	rm.latency["select"].observe(elapse)
+	if err != nil {
+		rm.errors["select"][errorCategory(err)]++
+		return
+	}
+	rm.rows["select"] += rows
+	rm.query.observe(queryElapse)
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ops := []string{"append", "select"}
+
+	fmt.Fprintln(w, "# HELP stress_info Scenario, stage, transport and format of the current run.")
+	fmt.Fprintln(w, "# TYPE stress_info gauge")
+	if m.run != nil {
+		fmt.Fprintf(w, "stress_info{scenario=\"%s\",stage=\"%s\",%s} 1\n", promEscape(m.scenario), promEscape(m.stage), m.run.labels())
+	}
+
+	fmt.Fprintln(w, "# HELP stress_workers Configured workers.")
+	fmt.Fprintln(w, "# TYPE stress_workers gauge")
+	for _, op := range ops {
+		fmt.Fprintf(w, "stress_workers{op=\"%s\"} %d\n", op, m.workers[op])
+	}
+	fmt.Fprintln(w, "# HELP stress_active_workers Requests in flight.")
+	fmt.Fprintln(w, "# TYPE stress_active_workers gauge")
+	for _, rm := range m.runs {
+		for _, op := range ops {
+			fmt.Fprintf(w, "stress_active_workers{op=\"%s\",%s} %d\n", op, rm.labels(), rm.active[op])
+		}
+	}
+	fmt.Fprintln(w, "# HELP stress_requests_total Requests sent, including failed ones.")
+	fmt.Fprintln(w, "# TYPE stress_requests_total counter")
+	for _, rm := range m.runs {
+		for _, op := range ops {
+			fmt.Fprintf(w, "stress_requests_total{op=\"%s\",%s} %d\n", op, rm.labels(), rm.requests[op])
+		}
+	}
+	fmt.Fprintln(w, "# HELP stress_rows_total Records appended and rows selected.")
+	fmt.Fprintln(w, "# TYPE stress_rows_total counter")
+	for _, rm := range m.runs {
+		for _, op := range ops {
+			fmt.Fprintf(w, "stress_rows_total{op=\"%s\",%s} %d\n", op, rm.labels(), rm.rows[op])
+		}
+	}
+	fmt.Fprintln(w, "# HELP stress_append_bytes_total Bytes of the appended payloads.")
+	fmt.Fprintln(w, "# TYPE stress_append_bytes_total counter")
+	for _, rm := range m.runs {
+		fmt.Fprintf(w, "stress_append_bytes_total{%s} %d\n", rm.labels(), rm.bytes["append"])
+	}
+
+	fmt.Fprintln(w, "# HELP stress_errors_total Failed requests by category.")
+	fmt.Fprintln(w, "# TYPE stress_errors_total counter")
+	for _, rm := range m.runs {
+		for _, op := range ops {
+			categories := make([]string, 0, len(rm.errors[op]))
+			for c := range rm.errors[op] {
+				categories = append(categories, c)
+			}
+			sort.Strings(categories)
+			for _, c := range categories {
+				fmt.Fprintf(w, "stress_errors_total{op=\"%s\",category=\"%s\",%s} %d\n", op, promEscape(c), rm.labels(), rm.errors[op][c])
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP stress_latency_seconds Client measured latency of requests.")
+	fmt.Fprintln(w, "# TYPE stress_latency_seconds histogram")
+	for _, rm := range m.runs {
+		for _, op := range ops {
+			writePromHistogram(w, "stress_latency_seconds", fmt.Sprintf("op=\"%s\",%s", op, rm.labels()), rm.latency[op])
+		}
+	}
+	fmt.Fprintln(w, "# HELP stress_select_query_seconds Server reported elapse of queries.")
+	fmt.Fprintln(w, "# TYPE stress_select_query_seconds histogram")
+	for _, rm := range m.runs {
+		writePromHistogram(w, "stress_select_query_seconds", rm.labels(), rm.query)
+	}
+}
+
+func writePromHistogram(w io.Writer, name string, labels string, h *promHistogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative int64
+	for i, le := range promBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(s string) string {
+	return promEscaper.Replace(s)
+}
+
+// ServeMetrics serves the metrics at http://addr/metrics until the returned
+// server is closed.
+func ServeMetrics(addr string, m *Metrics) (*http.Server, error) {
+	lsnr, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	svr := &http.Server{Handler: mux}
+	go svr.Serve(lsnr)
+	return svr, nil
+}
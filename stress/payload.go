@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PayloadFormat is the encoding of the append request body.
+//
+//   - csv: text/csv, a record per line, a string is quoted by the rules of encoding/csv
+//     if it has a comma, a quote or a newline
+//   - json: application/json, {"data":{"columns":[...],"rows":[[...],...]}}
+//   - ndjson: application/x-ndjson, an object of the column names per line
+//
+// Gzip compresses the body with Content-Encoding: gzip.
+// Timestamps are written in nanoseconds in every format.
+type PayloadFormat struct {
+	Name string
+	Gzip bool
+}
+
+const (
+	PayloadCSV    = "csv"
+	PayloadJSON   = "json"
+	PayloadNDJSON = "ndjson"
+)
+
+func (f PayloadFormat) String() string {
+	if f.Gzip {
+		return f.Name + "+gzip"
+	}
+	return f.Name
+}
+
+func (f PayloadFormat) ContentType() string {
+	switch f.Name {
+	case PayloadJSON:
+		return "application/json"
+	case PayloadNDJSON:
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// ParsePayloadFormats parses the -append-format flag,
+// a comma separated list of "format[+gzip]".
+func ParsePayloadFormats(str string) ([]PayloadFormat, error) {
+	ret := []PayloadFormat{}
+	for _, item := range strings.Split(str, ",") {
+		name, compress, _ := strings.Cut(strings.TrimSpace(item), "+")
+		f := PayloadFormat{Name: name}
+		switch compress {
+		case "":
+		case "gzip":
+			f.Gzip = true
+		default:
+			return nil, fmt.Errorf("invalid -append-format %q, unknown compression %q", item, compress)
+		}
+		switch name {
+		case PayloadCSV, PayloadJSON, PayloadNDJSON:
+		default:
+			return nil, fmt.Errorf("invalid -append-format %q, use csv, json or ndjson", item)
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// AppendBatch is the records of an append request and their encoded body.
+type AppendBatch struct {
+	Records [][]any
+	Body    []byte
+	RawSize int           // size of the body before compression
+	Encode  time.Duration // time to encode and compress the body
+}
+
+// Encode encodes the records of the columns into the batch body.
+func (f PayloadFormat) Encode(columns []string, records [][]any) (*AppendBatch, error) {
+	start := time.Now()
+	buf := &bytes.Buffer{}
+	switch f.Name {
+	case PayloadJSON:
+		data := struct {
+			Data struct {
+				Columns []string `json:"columns"`
+				Rows    [][]any  `json:"rows"`
+			} `json:"data"`
+		}{}
+		data.Data.Columns, data.Data.Rows = columns, records
+		if err := json.NewEncoder(buf).Encode(data); err != nil {
+			return nil, err
+		}
+	case PayloadNDJSON:
+		enc := json.NewEncoder(buf)
+		obj := make(map[string]any, len(columns))
+		for _, rec := range records {
+			for i, c := range columns {
+				obj[c] = rec[i]
+			}
+			if err := enc.Encode(obj); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		cw := csv.NewWriter(buf)
+		fields := []string{}
+		for _, rec := range records {
+			fields = fields[:0]
+			for _, v := range rec {
+				fields = append(fields, csvValue(v))
+			}
+			if err := cw.Write(fields); err != nil {
+				return nil, err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return nil, err
+		}
+	}
+	batch := &AppendBatch{Records: records, Body: buf.Bytes(), RawSize: buf.Len()}
+	if f.Gzip {
+		zbuf := &bytes.Buffer{}
+		zw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(zw)
+		zw.Reset(zbuf)
+		if _, err := zw.Write(batch.Body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		batch.Body = zbuf.Bytes()
+	}
+	batch.Encode = time.Since(start)
+	return batch, nil
+}
+
+// gzipWriters are reused, a gzip.Writer allocates about 800KB.
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+func csvValue(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', 6, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Result is a machine-readable record of a statistics cycle,
+// of a stage or of the final summary of a run.
+type Result struct {
+	Time          time.Time        `json:"time"`
+	Type          string           `json:"type"` // "cycle", "stage" or "summary"
+	Scenario      string           `json:"scenario"`
+	Transport     string           `json:"transport"`
+	Format        string           `json:"format"` // payload format of the appends
+	Seed          int64            `json:"seed"`   // replays the data and the queries with -seed
+	Stage         string           `json:"stage,omitempty"`
+	ElapsedSec    float64          `json:"elapsed_sec"`  // since the start of the run
+	DurationSec   float64          `json:"duration_sec"` // period of the record
+	AppendWorkers int              `json:"append_workers"`
+	SelectWorkers int              `json:"select_workers"`
+	Select        SelectResult     `json:"select"`
+	Append        AppendResult     `json:"append"`
+	Verify        *VerifyResult    `json:"verify,omitempty"`
+	Lag           *LagResult       `json:"lag,omitempty"`
+	Endpoints     []EndpointResult `json:"endpoints,omitempty"` // if there are more than one
+	Errors        map[string]int64 `json:"errors,omitempty"`
+}
+
+// SelectResult and AppendResult count the successful requests, Errors are the requests
+// failed after the retries and Attempts all the requests sent including the retries.
+// The Errors of Result count every failed attempt by its category.
+type SelectResult struct {
+	Count    int64   `json:"count"`
+	Rows     int64   `json:"rows"`
+	Errors   int64   `json:"errors"`
+	Attempts int64   `json:"attempts"`
+	PerSec   float64 `json:"per_sec"`
+	Http     Latency `json:"http"`
+	Query    Latency `json:"query"`
+	Missed   int64   `json:"missed"`
+	Queue    Latency `json:"queue"`
+	// Templates are the statistics of every query template of the scenario,
+	// if it has more than one.
+	Templates []TemplateResult `json:"templates,omitempty"`
+}
+
+type TemplateResult struct {
+	Name   string  `json:"name"`
+	Count  int64   `json:"count"`
+	Rows   int64   `json:"rows"`
+	Errors int64   `json:"errors"`
+	PerSec float64 `json:"per_sec"`
+	Http   Latency `json:"http"`
+	Query  Latency `json:"query"`
+}
+
+type AppendResult struct {
+	Count         int64   `json:"count"`
+	Errors        int64   `json:"errors"`
+	Attempts      int64   `json:"attempts"`
+	Records       int64   `json:"records"`
+	Bytes         int64   `json:"bytes"` // on the wire
+	RawBytes      int64   `json:"raw_bytes"`
+	Non200        int64   `json:"non_200"`
+	Fail          int64   `json:"fail"`
+	PerSec        float64 `json:"per_sec"`
+	RecordsPerSec float64 `json:"records_per_sec"`
+	BytesPerSec   float64 `json:"bytes_per_sec"`
+	Http          Latency `json:"http"`
+	Encode        Latency `json:"encode"`
+	Missed        int64   `json:"missed"`
+	Queue         Latency `json:"queue"`
+}
+
+// EndpointResult is the statistics of the requests to an http endpoint.
+type EndpointResult struct {
+	Endpoint      string  `json:"endpoint"`
+	AppendCount   int64   `json:"append_count"`
+	AppendRecords int64   `json:"append_records"`
+	AppendErrors  int64   `json:"append_errors"`
+	AppendPerSec  float64 `json:"append_per_sec"`
+	AppendHttp    Latency `json:"append_http"`
+	SelectCount   int64   `json:"select_count"`
+	SelectRows    int64   `json:"select_rows"`
+	SelectErrors  int64   `json:"select_errors"`
+	SelectPerSec  float64 `json:"select_per_sec"`
+	SelectHttp    Latency `json:"select_http"`
+}
+
+// LagResult is the visibility lag of the markers of the lag probe
+// on the raw table and on the rollup.
+type LagResult struct {
+	Raw    LagPathResult `json:"raw"`
+	Rollup LagPathResult `json:"rollup"`
+}
+
+// LagPathResult is the lag of a path, Invisible is the number of markers
+// that were not visible until the lag timeout.
+type LagPathResult struct {
+	Markers   int64   `json:"markers"`
+	Invisible int64   `json:"invisible"`
+	Delay     Latency `json:"delay"`
+}
+
+// VerifyResult is the outcome of the read-after-write verification,
+// Delay is the time until the appended rows were visible.
+type VerifyResult struct {
+	Ranges    int64   `json:"ranges"`
+	Rows      int64   `json:"rows"`
+	Missing   int64   `json:"missing"`
+	Duplicate int64   `json:"duplicate"`
+	Corrupt   int64   `json:"corrupt"`
+	Invisible int64   `json:"invisible"`
+	Skipped   int64   `json:"skipped"`
+	Delay     Latency `json:"delay"`
+}
+
+// Latency is the distribution of a histogram in milliseconds.
+type Latency struct {
+	Avg  float64 `json:"avg_ms"`
+	Min  float64 `json:"min_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p99_9_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+func NewLatency(h *Histogram) Latency {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return Latency{
+		Avg:  ms(h.Mean()),
+		Min:  ms(h.Min()),
+		P50:  ms(h.Percentile(50)),
+		P90:  ms(h.Percentile(90)),
+		P99:  ms(h.Percentile(99)),
+		P999: ms(h.Percentile(99.9)),
+		Max:  ms(h.Max()),
+	}
+}
+
+func (l Latency) fields() []float64 {
+	return []float64{l.Avg, l.Min, l.P50, l.P90, l.P99, l.P999, l.Max}
+}
+
+var latencyColumns = []string{"avg_ms", "min_ms", "p50_ms", "p90_ms", "p99_ms", "p99_9_ms", "max_ms"}
+
+// ResultWriter writes results to a file.
+type ResultWriter interface {
+	Write(r *Result) error
+	// WriteTelemetry writes the server views sampled at the end of a cycle.
+	WriteTelemetry(t *TelemetrySample) error
+	Close() error
+}
+
+// JSONResultWriter writes a JSON object per line.
+type JSONResultWriter struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func NewJSONResultWriter(path string) (*JSONResultWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONResultWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (w *JSONResultWriter) Write(r *Result) error {
+	return w.enc.Encode(r)
+}
+
+func (w *JSONResultWriter) WriteTelemetry(t *TelemetrySample) error {
+	return w.enc.Encode(t)
+}
+
+func (w *JSONResultWriter) Close() error {
+	return w.f.Close()
+}
+
+// CSVResultWriter writes a row per result with a header row,
+// the errors are written as the total count.
+// The telemetry is not written, the rows of the views do not fit the columns.
+type CSVResultWriter struct {
+	f   *os.File
+	w   *csv.Writer
+	hdr bool
+}
+
+func NewCSVResultWriter(path string) (*CSVResultWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &CSVResultWriter{f: f, w: csv.NewWriter(f)}, nil
+}
+
+func (w *CSVResultWriter) Write(r *Result) error {
+	if !w.hdr {
+		w.hdr = true
+		if err := w.w.Write(csvHeader()); err != nil {
+			return err
+		}
+	}
+	if err := w.w.Write(r.csvRecord()); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *CSVResultWriter) WriteTelemetry(t *TelemetrySample) error {
+	return nil
+}
+
+func (w *CSVResultWriter) Close() error {
+	w.w.Flush()
+	return w.f.Close()
+}
+
+func csvHeader() []string {
+	hdr := []string{"time", "type", "scenario", "transport", "format", "seed", "stage", "elapsed_sec", "duration_sec",
+		"append_workers", "select_workers",
+		"select_count", "select_rows", "select_errors", "select_attempts", "select_per_sec"}
+	hdr = appendLatencyColumns(hdr, "select_http_")
+	hdr = appendLatencyColumns(hdr, "select_query_")
+	hdr = append(hdr, "select_missed")
+	hdr = appendLatencyColumns(hdr, "select_queue_")
+	hdr = append(hdr, "append_count", "append_errors", "append_attempts", "append_records", "append_bytes", "append_raw_bytes", "append_non_200", "append_fail",
+		"append_per_sec", "append_records_per_sec", "append_bytes_per_sec")
+	hdr = appendLatencyColumns(hdr, "append_http_")
+	hdr = appendLatencyColumns(hdr, "append_encode_")
+	hdr = append(hdr, "append_missed")
+	hdr = appendLatencyColumns(hdr, "append_queue_")
+	hdr = append(hdr, "verify_ranges", "verify_rows", "verify_missing", "verify_duplicate",
+		"verify_corrupt", "verify_invisible", "verify_skipped")
+	hdr = appendLatencyColumns(hdr, "verify_delay_")
+	hdr = append(hdr, "lag_raw_markers", "lag_raw_invisible")
+	hdr = appendLatencyColumns(hdr, "lag_raw_delay_")
+	hdr = append(hdr, "lag_rollup_markers", "lag_rollup_invisible")
+	hdr = appendLatencyColumns(hdr, "lag_rollup_delay_")
+	hdr = append(hdr, "errors")
+	return hdr
+}
+
+func appendLatencyColumns(hdr []string, prefix string) []string {
+	for _, c := range latencyColumns {
+		hdr = append(hdr, prefix+c)
+	}
+	return hdr
+}
+
+func (r *Result) csvRecord() []string {
+	i := func(v int64) string { return strconv.FormatInt(v, 10) }
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	lat := func(rec []string, l Latency) []string {
+		for _, v := range l.fields() {
+			rec = append(rec, f(v))
+		}
+		return rec
+	}
+	var errs int64
+	for _, v := range r.Errors {
+		errs += v
+	}
+	rec := []string{r.Time.Format(time.RFC3339Nano), r.Type, r.Scenario, r.Transport, r.Format, i(r.Seed), r.Stage, f(r.ElapsedSec), f(r.DurationSec),
+		strconv.Itoa(r.AppendWorkers), strconv.Itoa(r.SelectWorkers),
+		i(r.Select.Count), i(r.Select.Rows), i(r.Select.Errors), i(r.Select.Attempts), f(r.Select.PerSec)}
+	rec = lat(rec, r.Select.Http)
+	rec = lat(rec, r.Select.Query)
+	rec = append(rec, i(r.Select.Missed))
+	rec = lat(rec, r.Select.Queue)
+	rec = append(rec, i(r.Append.Count), i(r.Append.Errors), i(r.Append.Attempts), i(r.Append.Records), i(r.Append.Bytes), i(r.Append.RawBytes), i(r.Append.Non200), i(r.Append.Fail),
+		f(r.Append.PerSec), f(r.Append.RecordsPerSec), f(r.Append.BytesPerSec))
+	rec = lat(rec, r.Append.Http)
+	rec = lat(rec, r.Append.Encode)
+	rec = append(rec, i(r.Append.Missed))
+	rec = lat(rec, r.Append.Queue)
+	verify := r.Verify
+	if verify == nil {
+		verify = &VerifyResult{}
+	}
+	rec = append(rec, i(verify.Ranges), i(verify.Rows), i(verify.Missing), i(verify.Duplicate),
+		i(verify.Corrupt), i(verify.Invisible), i(verify.Skipped))
+	rec = lat(rec, verify.Delay)
+	lag := r.Lag
+	if lag == nil {
+		lag = &LagResult{}
+	}
+	rec = append(rec, i(lag.Raw.Markers), i(lag.Raw.Invisible))
+	rec = lat(rec, lag.Raw.Delay)
+	rec = append(rec, i(lag.Rollup.Markers), i(lag.Rollup.Invisible))
+	rec = lat(rec, lag.Rollup.Delay)
+	rec = append(rec, i(errs))
+	return rec
+}
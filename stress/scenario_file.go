@@ -0,0 +1,500 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed scenarios/*.json
+var bundledScenarios embed.FS
+
+// ScenarioFile is the declarative form of a Scenario.
+//
+//	{
+//	  "create_table": "CREATE TAG TABLE ...",
+//	  "drop_table": "DROP TABLE ...",
+//	  "timeout": "1m",
+//	  "append": {
+//	    "uri": "/db/write/test_table?method=append",
+//	    "workers": 10, "runs_per_second": 10, "records_per_run": 10,
+//	    "vars": [ {"name": "id", "expr": "rand(1000)"} ],
+//	    "columns": [
+//	      {"type": "tag", "template": "tag_{worker}_{id}"},
+//	      {"type": "timestamp"},
+//	      {"type": "random"}
+//	    ]
+//	  },
+//	  "select": {
+//	    "workers": "cpu * 2", "runs_per_second": 10,
+//	    "queries": [
+//	      {"name": "recent", "weight": 1, "sql": "SELECT * FROM test_table WHERE time > {now-10s}"}
+//	    ]
+//	  },
+//	  "stages": [
+//	    {"name": "warmup", "duration": "1m", "shape": "ramp", "append_rate": 100, "select_rate": 10}
+//	  ]
+//	}
+//
+// SQL texts may be written as a string or as an array of lines.
+type ScenarioFile struct {
+	Name           string      `json:"name,omitempty"`
+	Description    string      `json:"description,omitempty"`
+	CreateTableSql Text        `json:"create_table,omitempty"`
+	DropTableSql   Text        `json:"drop_table,omitempty"`
+	Timeout        Duration    `json:"timeout,omitempty"`
+	Append         AppendSpec  `json:"append"`
+	Select         SelectSpec  `json:"select"`
+	Stages         []StageSpec `json:"stages,omitempty"`
+	// Telemetry are the server views of the scenario for -telemetry,
+	// they are sampled by default if -telemetry is not given.
+	Telemetry []TelemetrySpec `json:"telemetry,omitempty"`
+}
+
+type TelemetrySpec struct {
+	Name string `json:"name"`
+	Sql  Text   `json:"sql"`
+}
+
+type AppendSpec struct {
+	Uri           string       `json:"uri"`
+	Workers       IntExpr      `json:"workers"`
+	RunsPerSecond IntExpr      `json:"runs_per_second"`
+	RecordsPerRun IntExpr      `json:"records_per_run"`
+	Active        string       `json:"active,omitempty"` // expression, a request is sent only if non-zero, e.g. "burst(10s, 50s, worker * 1s)"
+	Vars          []VarSpec    `json:"vars,omitempty"`
+	Columns       []ColumnSpec `json:"columns"`
+}
+
+type SelectSpec struct {
+	Workers       IntExpr     `json:"workers"`
+	RunsPerSecond IntExpr     `json:"runs_per_second"`
+	Queries       []QuerySpec `json:"queries"`
+}
+
+// VarSpec defines a variable evaluated before the columns or the query,
+// in the order of declaration.
+type VarSpec struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+}
+
+// ColumnSpec is a typed generator of one CSV column.
+//
+//   - tag, text: "template" is rendered, e.g. "tag_{worker}_{nth}"
+//   - int: "expr" is evaluated
+//   - timestamp: "now" in nanoseconds plus optional "offset" expression, e.g. "late(5, 1m)"
+//   - random: uniform float in ["min", "max"), default [0, 1)
+//   - gaussian: normal distribution of "mean" and "stddev"
+type ColumnSpec struct {
+	Name     string  `json:"name,omitempty"`
+	Type     string  `json:"type"`
+	Template string  `json:"template,omitempty"`
+	Expr     string  `json:"expr,omitempty"`
+	Offset   string  `json:"offset,omitempty"`
+	Min      float64 `json:"min,omitempty"`
+	Max      float64 `json:"max,omitempty"`
+	Mean     float64 `json:"mean,omitempty"`
+	Stddev   float64 `json:"stddev,omitempty"`
+}
+
+type QuerySpec struct {
+	Name   string              `json:"name,omitempty"`
+	Weight int                 `json:"weight,omitempty"`
+	Sql    Text                `json:"sql"`
+	Vars   []VarSpec           `json:"vars,omitempty"`
+	Lists  map[string]ListSpec `json:"lists,omitempty"`
+}
+
+// ListSpec renders "item" template "count" times joined by "sep" (default ",").
+type ListSpec struct {
+	Count int     `json:"count"`
+	Sep   *string `json:"sep,omitempty"`
+	Item  string  `json:"item"`
+}
+
+// Text is a string that can be written as an array of lines in JSON.
+type Text string
+
+func (t *Text) UnmarshalJSON(b []byte) error {
+	var lines []string
+	if err := json.Unmarshal(b, &lines); err == nil {
+		*t = Text(strings.Join(lines, "\n"))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*t = Text(s)
+	return nil
+}
+
+// Duration is a time.Duration written as "10s", "1m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// IntExpr is a number or an expression string like "cpu * 2".
+type IntExpr string
+
+func (ie *IntExpr) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err == nil {
+		*ie = IntExpr(n.String())
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*ie = IntExpr(s)
+	return nil
+}
+
+func (ie IntExpr) Int() (int, error) {
+	if ie == "" {
+		return 0, nil
+	}
+	e, err := ParseExpr(string(ie))
+	if err != nil {
+		return 0, err
+	}
+	env := NewEnv()
+	env.Set("cpu", int64(runtime.NumCPU()))
+	return int(e.Eval(env)), nil
+}
+
+// BundledScenarioNames returns the names of the scenario files shipped with the binary.
+func BundledScenarioNames() []string {
+	entries, _ := bundledScenarios.ReadDir("scenarios")
+	names := []string{}
+	for _, ent := range entries {
+		names = append(names, strings.TrimSuffix(ent.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadScenario loads a bundled scenario by name or a scenario file by path.
+func LoadScenario(nameOrPath string) (Scenario, error) {
+	var r io.ReadCloser
+	if f, err := bundledScenarios.Open(path.Join("scenarios", nameOrPath+".json")); err == nil {
+		r = f
+	} else if f, err := os.Open(nameOrPath); err == nil {
+		r = f
+	} else {
+		return Scenario{}, fmt.Errorf("scenario %q not found", nameOrPath)
+	}
+	defer r.Close()
+
+	sf := ScenarioFile{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sf); err != nil {
+		return Scenario{}, fmt.Errorf("scenario %q: %w", nameOrPath, err)
+	}
+	s, err := sf.Compile()
+	if err != nil {
+		return Scenario{}, fmt.Errorf("scenario %q: %w", nameOrPath, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(nameOrPath), ".json")
+	}
+	return s, nil
+}
+
+// appendTable returns the table of the append uri "/db/write/<table>?...".
+func appendTable(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	table, ok := strings.CutPrefix(u.Path, "/db/write/")
+	if !ok || table == "" {
+		return "", fmt.Errorf("uri %q is not /db/write/<table>", uri)
+	}
+	return table, nil
+}
+
+// Compile builds a Scenario whose record and query functions are driven by the file.
+func (sf ScenarioFile) Compile() (Scenario, error) {
+	s := Scenario{
+		Name:           sf.Name,
+		CreateTableSql: string(sf.CreateTableSql),
+		DropTableSql:   string(sf.DropTableSql),
+		AppendUri:      sf.Append.Uri,
+		Timeout:        time.Duration(sf.Timeout),
+	}
+	var err error
+	if s.AppendWorker, err = sf.Append.Workers.Int(); err != nil {
+		return s, fmt.Errorf("append.workers: %w", err)
+	}
+	if s.AppendWorkerRunPerSecond, err = sf.Append.RunsPerSecond.Int(); err != nil {
+		return s, fmt.Errorf("append.runs_per_second: %w", err)
+	}
+	if s.AppendRecordsPerRun, err = sf.Append.RecordsPerRun.Int(); err != nil {
+		return s, fmt.Errorf("append.records_per_run: %w", err)
+	}
+	if s.SelectWorker, err = sf.Select.Workers.Int(); err != nil {
+		return s, fmt.Errorf("select.workers: %w", err)
+	}
+	if s.SelectWorkerRunPerSecond, err = sf.Select.RunsPerSecond.Int(); err != nil {
+		return s, fmt.Errorf("select.runs_per_second: %w", err)
+	}
+	for i, spec := range sf.Telemetry {
+		if spec.Name == "" || spec.Sql == "" {
+			return s, fmt.Errorf("telemetry[%d]: name and sql are required", i)
+		}
+		s.Telemetry = append(s.Telemetry, TelemetryView{Name: spec.Name, Sql: string(spec.Sql)})
+	}
+	for i, spec := range sf.Stages {
+		st, err := spec.Stage(i)
+		if err != nil {
+			return s, err
+		}
+		s.Stages = append(s.Stages, st)
+	}
+	if s.AppendWorker > 0 || len(sf.Append.Columns) > 0 {
+		if s.AppendRecordDataFunc, err = sf.Append.compile(); err != nil {
+			return s, err
+		}
+		if s.AppendActiveFunc, err = sf.Append.compileActive(); err != nil {
+			return s, err
+		}
+		s.VerifyTarget, s.verifyErr = sf.Append.verifyTarget()
+		for _, c := range sf.Append.Columns {
+			s.AppendColumns = append(s.AppendColumns, c.Name)
+			s.AppendColumnTypes = append(s.AppendColumnTypes, c.Type)
+		}
+	}
+	if s.SelectWorker > 0 || len(sf.Select.Queries) > 0 {
+		if s.SelectSqlFunc, err = sf.Select.compile(); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+type compiledVar struct {
+	name string
+	expr Expr
+}
+
+func compileVars(specs []VarSpec) ([]compiledVar, error) {
+	ret := make([]compiledVar, 0, len(specs))
+	for _, v := range specs {
+		e, err := ParseExpr(v.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("var %q: %w", v.Name, err)
+		}
+		ret = append(ret, compiledVar{name: v.Name, expr: e})
+	}
+	return ret, nil
+}
+
+func evalVars(vars []compiledVar, env *Env) {
+	for _, v := range vars {
+		env.Set(v.name, v.expr.Eval(env))
+	}
+}
+
+// columnFunc generates the value of a column, string, int64 (timestamp in nanoseconds) or float64.
+type columnFunc func(env *Env) any
+
+func (spec AppendSpec) compile() (func(rng *rand.Rand, workerId int, now time.Time, nRun int, nRecord int) []any, error) {
+	vars, err := compileVars(spec.Vars)
+	if err != nil {
+		return nil, fmt.Errorf("append: %w", err)
+	}
+	if len(spec.Columns) == 0 {
+		return nil, fmt.Errorf("append: no columns")
+	}
+	columns := make([]columnFunc, 0, len(spec.Columns))
+	for i, c := range spec.Columns {
+		fn, err := c.compile()
+		if err != nil {
+			return nil, fmt.Errorf("append.columns[%d]: %w", i, err)
+		}
+		columns = append(columns, fn)
+	}
+	return func(rng *rand.Rand, workerId int, now time.Time, nRun int, nRecord int) []any {
+		env := NewEnvRand(rng)
+		env.Set("now", now.UnixNano())
+		env.Set("worker", int64(workerId))
+		env.Set("run", int64(nRun))
+		env.Set("nth", int64(nRecord))
+		evalVars(vars, env)
+		rec := make([]any, len(columns))
+		for i, col := range columns {
+			rec[i] = col(env)
+		}
+		return rec
+	}, nil
+}
+
+// compileActive returns nil if the source is always active.
+func (spec AppendSpec) compileActive() (func(rng *rand.Rand, workerId int, now time.Time, nRun int) bool, error) {
+	if spec.Active == "" {
+		return nil, nil
+	}
+	e, err := ParseExpr(spec.Active)
+	if err != nil {
+		return nil, fmt.Errorf("append.active: %w", err)
+	}
+	return func(rng *rand.Rand, workerId int, now time.Time, nRun int) bool {
+		env := NewEnvRand(rng)
+		env.Set("now", now.UnixNano())
+		env.Set("worker", int64(workerId))
+		env.Set("run", int64(nRun))
+		return e.Eval(env) != 0
+	}, nil
+}
+
+func (c ColumnSpec) compile() (columnFunc, error) {
+	switch c.Type {
+	case "tag", "text":
+		t, err := ParseTemplate(c.Template, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func(env *Env) any { return t.Execute(env) }, nil
+	case "int":
+		e, err := ParseExpr(c.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(env *Env) any { return e.Eval(env) }, nil
+	case "timestamp":
+		offset := Expr(exprFunc(func(*Env) int64 { return 0 }))
+		if c.Offset != "" {
+			e, err := ParseExpr(c.Offset)
+			if err != nil {
+				return nil, err
+			}
+			offset = e
+		}
+		return func(env *Env) any {
+			now, _ := env.Get("now")
+			return now + offset.Eval(env)
+		}, nil
+	case "random":
+		lo, hi := c.Min, c.Max
+		if lo == 0 && hi == 0 {
+			hi = 1
+		}
+		return func(env *Env) any {
+			return roundValue(lo + env.Rand().Float64()*(hi-lo))
+		}, nil
+	case "gaussian":
+		mean, stddev := c.Mean, c.Stddev
+		if stddev == 0 {
+			stddev = 1
+		}
+		return func(env *Env) any {
+			return roundValue(mean + env.Rand().NormFloat64()*stddev)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown column type %q", c.Type)
+}
+
+// roundValue rounds to 6 decimal places, so that every payload format
+// carries the same value as the CSV "%f".
+func roundValue(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+type compiledQuery struct {
+	name   string
+	weight int
+	vars   []compiledVar
+	sql    *Template
+}
+
+// compile returns the function that picks a query by the weights
+// and returns its name and SQL text.
+func (spec SelectSpec) compile() (func(rng *rand.Rand, workerId int, now time.Time) (string, string), error) {
+	if len(spec.Queries) == 0 {
+		return nil, fmt.Errorf("select: no queries")
+	}
+	queries := make([]compiledQuery, 0, len(spec.Queries))
+	totalWeight := 0
+	for i, q := range spec.Queries {
+		cq, err := q.compile()
+		if err != nil {
+			return nil, fmt.Errorf("select.queries[%d]: %w", i, err)
+		}
+		if cq.name == "" {
+			cq.name = fmt.Sprintf("query-%d", i)
+		}
+		totalWeight += cq.weight
+		queries = append(queries, cq)
+	}
+	return func(rng *rand.Rand, workerId int, now time.Time) (string, string) {
+		env := NewEnvRand(rng)
+		q := &queries[0]
+		if len(queries) > 1 {
+			n := env.Rand().Intn(totalWeight)
+			for i := range queries {
+				if n < queries[i].weight {
+					q = &queries[i]
+					break
+				}
+				n -= queries[i].weight
+			}
+		}
+		env.Set("now", now.UnixNano())
+		env.Set("worker", int64(workerId))
+		evalVars(q.vars, env)
+		return q.name, q.sql.Execute(env)
+	}, nil
+}
+
+func (q QuerySpec) compile() (compiledQuery, error) {
+	cq := compiledQuery{name: q.Name, weight: q.Weight}
+	if cq.weight <= 0 {
+		cq.weight = 1
+	}
+	var err error
+	if cq.vars, err = compileVars(q.Vars); err != nil {
+		return cq, err
+	}
+	lists := map[string]*List{}
+	for name, ls := range q.Lists {
+		item, err := ParseTemplate(ls.Item, nil)
+		if err != nil {
+			return cq, fmt.Errorf("list %q: %w", name, err)
+		}
+		sep := ","
+		if ls.Sep != nil {
+			sep = *ls.Sep
+		}
+		lists[name] = &List{Count: ls.Count, Sep: sep, Item: item}
+	}
+	if cq.sql, err = ParseTemplate(string(q.Sql), lists); err != nil {
+		return cq, err
+	}
+	return cq, nil
+}
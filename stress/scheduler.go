@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// openLoopQueueSize is the number of scheduled requests that can wait
+// for a free in-flight slot, more than that are counted as missed.
+const openLoopQueueSize = 10000
+
+// openLoopIdleInterval is the longest period before the rate is checked again.
+const openLoopIdleInterval = 10 * time.Millisecond
+
+type openLoopJob struct {
+	seq      int64
+	intended time.Time
+}
+
+// RunOpenLoop schedules job at the arrival rate (per second) returned by
+// rate for the elapsed time of the schedule, until closeCh is closed.
+// The schedule does not depend on the completion of the previous jobs,
+// so a slow server does not lower the offered load.
+// Up to maxInflight jobs run concurrently, the others wait in a queue.
+// job receives the intended start time of the schedule, so that the latency
+// includes the queueing delay. When the queue is full, missed is called
+// instead of the job.
+func RunOpenLoop(closeCh <-chan struct{}, rate func(elapsed time.Duration) float64, maxInflight int, job func(seq int64, intended time.Time), missed func()) {
+	if maxInflight <= 0 {
+		maxInflight = 1
+	}
+	queue := make(chan openLoopJob, openLoopQueueSize)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < maxInflight; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				select {
+				case <-closeCh:
+					// drain the queue without running
+					continue
+				default:
+				}
+				job(j.seq, j.intended)
+			}
+		}()
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	start := time.Now()
+	intended := start
+	seq := int64(0)
+	credit := 0.0
+loop:
+	for {
+		r := rate(intended.Sub(start))
+		if wait := time.Until(intended); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-closeCh:
+				break loop
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-closeCh:
+				break loop
+			default:
+			}
+		}
+		at := intended
+		if r*openLoopIdleInterval.Seconds() < 1 {
+			// low rate, that may change before the interval passes,
+			// accumulates the fraction of a request every idle interval.
+			credit += r * openLoopIdleInterval.Seconds()
+			intended = intended.Add(openLoopIdleInterval)
+			if credit < 1 {
+				continue
+			}
+			credit--
+		} else {
+			intended = intended.Add(time.Duration(float64(time.Second) / r))
+		}
+		select {
+		case queue <- openLoopJob{seq: seq, intended: at}:
+		default:
+			missed()
+		}
+		seq++
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// ConstantRate returns a rate function of RunOpenLoop.
+func ConstantRate(rate float64) func(time.Duration) float64 {
+	return func(time.Duration) float64 { return rate }
+}
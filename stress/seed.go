@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// A run is reproducible by its seed. Every worker has its own RNG derived
+// from the seed, the op and the worker id, and the n-th request of a worker
+// draws from a stream of the worker RNG and n. So the n-th request of a worker
+// carries the same tags, values and query parameters on every run of the seed,
+// even if the requests of the worker are concurrent in -open-loop.
+
+// Ops of the derived RNGs
+const (
+	seedAppend = iota + 1
+	seedSelect
+)
+
+// NewSeed returns a random seed for a run without -seed.
+func NewSeed() int64 {
+	for {
+		if seed := time.Now().UnixNano() ^ rand.Int63(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// splitMix64 is a rand.Source64 of a single word of state,
+// cheap enough to create for every request.
+type splitMix64 uint64
+
+func (s *splitMix64) Seed(seed int64) {
+	*s = splitMix64(seed)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// deriveSeed mixes the keys into the seed.
+func deriveSeed(seed int64, keys ...int64) int64 {
+	s := splitMix64(seed)
+	for _, k := range keys {
+		s = splitMix64(s.Uint64() ^ uint64(k))
+	}
+	return int64(s.Uint64())
+}
+
+// workerRand returns the RNG of the n-th request of the worker of the op.
+func workerRand(seed int64, op int64, workerId int, n int) *rand.Rand {
+	src := splitMix64(deriveSeed(seed, op, int64(workerId), int64(n)))
+	return rand.New(&src)
+}
+
+// globalSource is the global math/rand source as a rand.Source,
+// for the expressions evaluated without a worker RNG.
+type globalSource struct{}
+
+func (globalSource) Int63() int64    { return rand.Int63() }
+func (globalSource) Uint64() uint64  { return rand.Uint64() }
+func (globalSource) Seed(seed int64) {}
+
+var globalRand = rand.New(globalSource{})
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage is a period of a load profile.
+//
+// Rates are requests per second, an append request carries
+// AppendRecordsPerRun/10 records.
+//
+// Shapes:
+//   - step: the rates jump to the targets at the start of the stage and hold,
+//     hold is an alias of it
+//   - ramp: the rates change linearly from the previous stage to the targets
+//   - spike: the rates jump to the targets for the first half of the stage,
+//     then return to the rates of the previous stage for the second half
+type Stage struct {
+	Name       string
+	Duration   time.Duration
+	Shape      string
+	AppendRate float64
+	SelectRate float64
+}
+
+const (
+	StageStep  = "step"
+	StageHold  = "hold"
+	StageRamp  = "ramp"
+	StageSpike = "spike"
+)
+
+// StageSpec is the JSON form of a Stage in a scenario file.
+type StageSpec struct {
+	Name       string   `json:"name,omitempty"`
+	Duration   Duration `json:"duration"`
+	Shape      string   `json:"shape,omitempty"`
+	AppendRate float64  `json:"append_rate"`
+	SelectRate float64  `json:"select_rate"`
+}
+
+func (spec StageSpec) Stage(idx int) (Stage, error) {
+	st := Stage{
+		Name:       spec.Name,
+		Duration:   time.Duration(spec.Duration),
+		Shape:      spec.Shape,
+		AppendRate: spec.AppendRate,
+		SelectRate: spec.SelectRate,
+	}
+	return st, st.validate(idx)
+}
+
+func (st *Stage) validate(idx int) error {
+	if st.Name == "" {
+		st.Name = fmt.Sprintf("stage-%d", idx)
+	}
+	if st.Shape == "" || st.Shape == StageHold {
+		st.Shape = StageStep
+	}
+	switch st.Shape {
+	case StageStep, StageRamp, StageSpike:
+	default:
+		return fmt.Errorf("stage %q: unknown shape %q, use step, hold, ramp or spike", st.Name, st.Shape)
+	}
+	if st.Duration <= 0 {
+		return fmt.Errorf("stage %q: duration should be positive", st.Name)
+	}
+	if st.AppendRate < 0 || st.SelectRate < 0 {
+		return fmt.Errorf("stage %q: negative rate", st.Name)
+	}
+	return nil
+}
+
+// ParseStages parses the -stages flag,
+// a comma separated list of "[name=]duration:shape:append_rate:select_rate"
+//
+//	warmup=1m:ramp:1000:100,hold=5m:step:1000:100,10s:spike:5000:500
+func ParseStages(str string) ([]Stage, error) {
+	ret := []Stage{}
+	for i, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		st := Stage{}
+		if name, rest, ok := strings.Cut(item, "="); ok {
+			st.Name, item = name, rest
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid stage %q, use [name=]duration:shape:append_rate:select_rate", item)
+		}
+		var err error
+		if st.Duration, err = time.ParseDuration(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid stage %q, %w", item, err)
+		}
+		st.Shape = fields[1]
+		if st.AppendRate, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, fmt.Errorf("invalid stage %q, %w", item, err)
+		}
+		if st.SelectRate, err = strconv.ParseFloat(fields[3], 64); err != nil {
+			return nil, fmt.Errorf("invalid stage %q, %w", item, err)
+		}
+		if err := st.validate(i); err != nil {
+			return nil, err
+		}
+		ret = append(ret, st)
+	}
+	return ret, nil
+}
+
+// StageProfile is a list of stages run one after another.
+type StageProfile []Stage
+
+func (sp StageProfile) Duration() time.Duration {
+	var total time.Duration
+	for _, st := range sp {
+		total += st.Duration
+	}
+	return total
+}
+
+// At returns the index of the stage and the append and select rates
+// at the elapsed time. The index is len(sp) after the last stage.
+func (sp StageProfile) At(elapsed time.Duration) (int, float64, float64) {
+	var prevAppend, prevSelect float64
+	for i, st := range sp {
+		if elapsed < st.Duration {
+			switch st.Shape {
+			case StageRamp:
+				f := float64(elapsed) / float64(st.Duration)
+				return i, prevAppend + (st.AppendRate-prevAppend)*f, prevSelect + (st.SelectRate-prevSelect)*f
+			case StageSpike:
+				if elapsed >= st.Duration/2 {
+					return i, prevAppend, prevSelect
+				}
+			}
+			return i, st.AppendRate, st.SelectRate
+		}
+		elapsed -= st.Duration
+		if st.Shape != StageSpike {
+			// the rates are back to the previous ones at the end of a spike
+			prevAppend, prevSelect = st.AppendRate, st.SelectRate
+		}
+	}
+	return len(sp), 0, 0
+}
+
+func (sp StageProfile) AppendRate(elapsed time.Duration) float64 {
+	_, r, _ := sp.At(elapsed)
+	return r
+}
+
+func (sp StageProfile) SelectRate(elapsed time.Duration) float64 {
+	_, _, r := sp.At(elapsed)
+	return r
+}
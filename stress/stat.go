@@ -0,0 +1,1094 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// StatCounters are the statistics of a period,
+// a cycle, a stage or the whole run.
+type StatCounters struct {
+	start time.Time
+	end   time.Time
+
+	selectCount     int64 // successful requests, as appendCount
+	selectRows      int64
+	selectErrors    int64 // requests failed after the retries, as appendErrors
+	selectAttempts  int64 // requests sent including the failed ones and the retries
+	selectMissed    int64
+	selectQueryHist *Histogram // server reported elapse
+	selectHttpHist  *Histogram // client measured wall time
+	selectQueueHist *Histogram // open-loop queueing delay
+	templates       map[string]*templateCounters
+	endpoints       map[string]*endpointCounters
+
+	appendCount      int64 // successful requests
+	appendErrors     int64
+	appendAttempts   int64
+	appendRecords    int64
+	appendBytes      int64 // on the wire
+	appendRawBytes   int64 // before compression
+	appendNon200     int64
+	appendFail       int64
+	appendMissed     int64
+	appendHttpHist   *Histogram
+	appendQueueHist  *Histogram
+	appendEncodeHist *Histogram
+
+	verifyRanges    int64 // verified append requests
+	verifyRows      int64
+	verifyMissing   int64
+	verifyDuplicate int64
+	verifyCorrupt   int64
+	verifyInvisible int64 // ranges not fully visible until the verify timeout
+	verifySkipped   int64 // rows not verified
+	verifyDelayHist *Histogram
+
+	lagRawMarkers      int64
+	lagRawInvisible    int64 // markers not visible until the lag timeout
+	lagRawHist         *Histogram
+	lagRollupMarkers   int64
+	lagRollupInvisible int64
+	lagRollupHist      *Histogram
+
+	errors map[string]int64 // "op/category" -> count
+}
+
+func NewStatCounters() *StatCounters {
+	return &StatCounters{
+		start:            time.Now(),
+		selectQueryHist:  NewHistogram(),
+		selectHttpHist:   NewHistogram(),
+		selectQueueHist:  NewHistogram(),
+		appendHttpHist:   NewHistogram(),
+		appendQueueHist:  NewHistogram(),
+		appendEncodeHist: NewHistogram(),
+		verifyDelayHist:  NewHistogram(),
+		lagRawHist:       NewHistogram(),
+		lagRollupHist:    NewHistogram(),
+		templates:        map[string]*templateCounters{},
+		endpoints:        map[string]*endpointCounters{},
+		errors:           map[string]int64{},
+	}
+}
+
+// templateCounters are the select statistics of a query template.
+type templateCounters struct {
+	count     int64
+	rows      int64
+	errors    int64
+	httpHist  *Histogram
+	queryHist *Histogram
+}
+
+// template returns the counters of the query template, created on first use.
+func (c *StatCounters) template(name string) *templateCounters {
+	tc := c.templates[name]
+	if tc == nil {
+		tc = &templateCounters{httpHist: NewHistogram(), queryHist: NewHistogram()}
+		c.templates[name] = tc
+	}
+	return tc
+}
+
+// endpointCounters are the statistics of the requests to an http endpoint.
+type endpointCounters struct {
+	appendCount   int64
+	appendRecords int64
+	appendErrors  int64
+	appendHist    *Histogram
+	selectCount   int64
+	selectRows    int64
+	selectErrors  int64
+	selectHist    *Histogram
+}
+
+// endpoint returns the counters of the endpoint, created on first use.
+func (c *StatCounters) endpoint(addr string) *endpointCounters {
+	ec := c.endpoints[addr]
+	if ec == nil {
+		ec = &endpointCounters{appendHist: NewHistogram(), selectHist: NewHistogram()}
+		c.endpoints[addr] = ec
+	}
+	return ec
+}
+
+// endpointNames returns the endpoints in order.
+func (c *StatCounters) endpointNames() []string {
+	names := make([]string, 0, len(c.endpoints))
+	for addr := range c.endpoints {
+		names = append(names, addr)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateNames returns the names of the query templates in order.
+func (c *StatCounters) templateNames() []string {
+	names := make([]string, 0, len(c.templates))
+	for name := range c.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *StatCounters) Merge(o *StatCounters) {
+	c.selectCount += o.selectCount
+	c.selectRows += o.selectRows
+	c.selectErrors += o.selectErrors
+	c.selectAttempts += o.selectAttempts
+	c.selectMissed += o.selectMissed
+	c.selectQueryHist.Merge(o.selectQueryHist)
+	c.selectHttpHist.Merge(o.selectHttpHist)
+	c.selectQueueHist.Merge(o.selectQueueHist)
+	c.appendCount += o.appendCount
+	c.appendErrors += o.appendErrors
+	c.appendAttempts += o.appendAttempts
+	c.appendRecords += o.appendRecords
+	c.appendBytes += o.appendBytes
+	c.appendRawBytes += o.appendRawBytes
+	c.appendNon200 += o.appendNon200
+	c.appendFail += o.appendFail
+	c.appendMissed += o.appendMissed
+	c.appendHttpHist.Merge(o.appendHttpHist)
+	c.appendQueueHist.Merge(o.appendQueueHist)
+	c.appendEncodeHist.Merge(o.appendEncodeHist)
+	c.verifyRanges += o.verifyRanges
+	c.verifyRows += o.verifyRows
+	c.verifyMissing += o.verifyMissing
+	c.verifyDuplicate += o.verifyDuplicate
+	c.verifyCorrupt += o.verifyCorrupt
+	c.verifyInvisible += o.verifyInvisible
+	c.verifySkipped += o.verifySkipped
+	c.verifyDelayHist.Merge(o.verifyDelayHist)
+	c.lagRawMarkers += o.lagRawMarkers
+	c.lagRawInvisible += o.lagRawInvisible
+	c.lagRawHist.Merge(o.lagRawHist)
+	c.lagRollupMarkers += o.lagRollupMarkers
+	c.lagRollupInvisible += o.lagRollupInvisible
+	c.lagRollupHist.Merge(o.lagRollupHist)
+	for name, ot := range o.templates {
+		tc := c.template(name)
+		tc.count += ot.count
+		tc.rows += ot.rows
+		tc.errors += ot.errors
+		tc.httpHist.Merge(ot.httpHist)
+		tc.queryHist.Merge(ot.queryHist)
+	}
+	for addr, oe := range o.endpoints {
+		ec := c.endpoint(addr)
+		ec.appendCount += oe.appendCount
+		ec.appendRecords += oe.appendRecords
+		ec.appendErrors += oe.appendErrors
+		ec.appendHist.Merge(oe.appendHist)
+		ec.selectCount += oe.selectCount
+		ec.selectRows += oe.selectRows
+		ec.selectErrors += oe.selectErrors
+		ec.selectHist.Merge(oe.selectHist)
+	}
+	for k, v := range o.errors {
+		c.errors[k] += v
+	}
+}
+
+func (c *StatCounters) Reset() {
+	*c = StatCounters{
+		start:            time.Now(),
+		selectQueryHist:  c.selectQueryHist,
+		selectHttpHist:   c.selectHttpHist,
+		selectQueueHist:  c.selectQueueHist,
+		appendHttpHist:   c.appendHttpHist,
+		appendQueueHist:  c.appendQueueHist,
+		appendEncodeHist: c.appendEncodeHist,
+		verifyDelayHist:  c.verifyDelayHist,
+		lagRawHist:       c.lagRawHist,
+		lagRollupHist:    c.lagRollupHist,
+		templates:        c.templates,
+		endpoints:        c.endpoints,
+		errors:           c.errors,
+	}
+	c.selectQueryHist.Reset()
+	c.selectHttpHist.Reset()
+	c.selectQueueHist.Reset()
+	c.appendHttpHist.Reset()
+	c.appendQueueHist.Reset()
+	c.appendEncodeHist.Reset()
+	c.verifyDelayHist.Reset()
+	c.lagRawHist.Reset()
+	c.lagRollupHist.Reset()
+	clear(c.templates)
+	clear(c.endpoints)
+	clear(c.errors)
+}
+
+// Duration is the length of the period, up to now if it is not ended.
+func (c *StatCounters) Duration() time.Duration {
+	if c.end.IsZero() {
+		return time.Since(c.start)
+	}
+	return c.end.Sub(c.start)
+}
+
+func (c *StatCounters) ErrorCount() int64 {
+	var n int64
+	for _, v := range c.errors {
+		n += v
+	}
+	return n
+}
+
+func (c *StatCounters) empty() bool {
+	return c.selectCount == 0 && c.appendCount == 0 && c.verifyRanges == 0 && c.verifySkipped == 0 &&
+		c.lagRawMarkers == 0 && c.lagRollupMarkers == 0 && len(c.errors) == 0
+}
+
+type Stat struct {
+	createdTime time.Time
+	cycle       *StatCounters
+	cumulative  *StatCounters
+	stages      []*stageStat // the last one is the current stage
+	summary     *Result
+
+	// Scenario, Transport, Format, Seed, AppendWorkers and SelectWorkers label the results.
+	Scenario      string
+	Transport     string
+	Format        string
+	Seed          int64
+	AppendWorkers int
+	SelectWorkers int
+	// Outputs receive a result of every cycle and of the summary.
+	Outputs []ResultWriter
+	// Telemetry samples the server views at the end of every cycle, if not nil.
+	Telemetry *TelemetrySampler
+
+	wg            sync.WaitGroup
+	selectC       chan SelectSample
+	appendC       chan AppendSample
+	errorC        chan errorSample
+	queueC        chan queueSample
+	verifyC       chan VerifySample
+	lagC          chan LagSample
+	stageC        chan string
+	commandC      chan StatCommand
+	telemetryC    chan *TelemetrySample
+	telemetryBusy bool // a sample is in progress
+	telemetryWg   sync.WaitGroup
+}
+
+type StatCommand string
+
+func NewStat() *Stat {
+	return &Stat{
+		createdTime: time.Now(),
+		cycle:       NewStatCounters(),
+		cumulative:  NewStatCounters(),
+		selectC:     make(chan SelectSample, 100),
+		appendC:     make(chan AppendSample, 100),
+		errorC:      make(chan errorSample, 100),
+		queueC:      make(chan queueSample, 100),
+		verifyC:     make(chan VerifySample, 100),
+		lagC:        make(chan LagSample, 100),
+		stageC:      make(chan string, 1),
+		commandC:    make(chan StatCommand, 10),
+		telemetryC:  make(chan *TelemetrySample, 1),
+	}
+}
+
+// SelectSample is the result of one select request of the query template,
+// Query is the elapsed time of the query and Elapse the wall time of the request.
+type SelectSample struct {
+	Endpoint string // empty if not an http endpoint
+	Template string
+	Rows     int64
+	Query    time.Duration
+	Elapse   time.Duration
+}
+
+func (stat *Stat) AddSelect(sample SelectSample) {
+	stat.selectC <- sample
+}
+
+// AppendSample is the result of one append request,
+// Bytes is the size of the body on the wire and RawBytes before compression.
+type AppendSample struct {
+	Endpoint string // empty if not an http endpoint
+	Records  int64
+	Bytes    int64
+	RawBytes int64
+	Encode   time.Duration
+	Elapse   time.Duration
+}
+
+func (stat *Stat) AddAppend(sample AppendSample) {
+	stat.appendC <- sample
+}
+
+type errorSample struct {
+	op       string
+	category string
+	endpoint string
+	template string // query template of a select
+	failed   bool   // the request failed after the retries, it is counted once
+}
+
+// AddError counts a failed attempt of an "append", "select" or "verify" request by its category.
+func (stat *Stat) AddError(op string, err error) {
+	stat.errorC <- errorSample{op: op, category: errorCategory(err)}
+}
+
+// AddAppendError counts a failed attempt of an append request to the endpoint.
+func (stat *Stat) AddAppendError(endpoint string, err error) {
+	stat.errorC <- errorSample{op: "append", category: errorCategory(err), endpoint: endpoint}
+}
+
+// AddSelectError counts a failed attempt of a select request of the query template to the endpoint.
+func (stat *Stat) AddSelectError(endpoint string, template string, err error) {
+	stat.errorC <- errorSample{op: "select", category: errorCategory(err), endpoint: endpoint, template: template}
+}
+
+// AddFailed counts an "append" or "select" request that failed after the retries
+// of the error policy, the errors of its attempts are counted by AddError.
+func (stat *Stat) AddFailed(op string) {
+	stat.errorC <- errorSample{op: op, failed: true}
+}
+
+// queueSample is the queueing delay of an open-loop request,
+// or a missed request if missed is true.
+type queueSample struct {
+	op     string
+	delay  time.Duration
+	missed bool
+}
+
+// AddQueueDelay records the delay between the intended and the actual start
+// of an open-loop "append" or "select" request.
+func (stat *Stat) AddQueueDelay(op string, delay time.Duration) {
+	stat.queueC <- queueSample{op: op, delay: delay}
+}
+
+// AddMissed counts an open-loop request that could not be scheduled.
+func (stat *Stat) AddMissed(op string) {
+	stat.queueC <- queueSample{op: op, missed: true}
+}
+
+// AddVerify records the outcome of the verification of an append request.
+func (stat *Stat) AddVerify(sample VerifySample) {
+	stat.verifyC <- sample
+}
+
+// AddLag records the visibility lag of a marker of the lag probe.
+func (stat *Stat) AddLag(sample LagSample) {
+	stat.lagC <- sample
+}
+
+func (stat *Stat) Start() {
+	stat.wg.Add(1)
+	go func() {
+		defer stat.wg.Done()
+		for {
+			select {
+			case sample := <-stat.selectC:
+				stat.recordSelect(sample)
+			case sample := <-stat.appendC:
+				stat.recordAppend(sample)
+			case sample := <-stat.errorC:
+				stat.recordError(sample)
+			case sample := <-stat.queueC:
+				stat.recordQueue(sample)
+			case sample := <-stat.verifyC:
+				stat.recordVerify(sample)
+			case sample := <-stat.lagC:
+				stat.recordLag(sample)
+			case sample := <-stat.telemetryC:
+				stat.telemetryBusy = false
+				stat.writeTelemetry(sample)
+			case name := <-stat.stageC:
+				stat.startStage(name)
+			case cmd := <-stat.commandC:
+				switch cmd {
+				case "stop":
+					// record the samples sent before Stop first
+					stat.drain()
+					stat.cycle.end = time.Now()
+					stat.cumulative.Merge(stat.cycle)
+					stat.cumulative.end = stat.cycle.end
+					if st := stat.currentStage(); st != nil {
+						st.end = stat.cycle.end
+					}
+					return
+				case "print-reset":
+					stat.endCycle()
+				default:
+					stat.print()
+				}
+			}
+		}
+	}()
+}
+
+// drain records the samples and the stages waiting in the channels.
+func (stat *Stat) drain() {
+	for {
+		select {
+		case sample := <-stat.selectC:
+			stat.recordSelect(sample)
+		case sample := <-stat.appendC:
+			stat.recordAppend(sample)
+		case sample := <-stat.errorC:
+			stat.recordError(sample)
+		case sample := <-stat.queueC:
+			stat.recordQueue(sample)
+		case sample := <-stat.verifyC:
+			stat.recordVerify(sample)
+		case sample := <-stat.lagC:
+			stat.recordLag(sample)
+		case sample := <-stat.telemetryC:
+			stat.telemetryBusy = false
+			stat.writeTelemetry(sample)
+		case name := <-stat.stageC:
+			stat.startStage(name)
+		default:
+			return
+		}
+	}
+}
+
+// recordSelect records a successful select request.
+func (stat *Stat) recordSelect(sample SelectSample) {
+	stat.record(func(c *StatCounters) {
+		c.selectCount++
+		c.selectAttempts++
+		c.selectRows += sample.Rows
+		c.selectQueryHist.Record(sample.Query)
+		c.selectHttpHist.Record(sample.Elapse)
+		tc := c.template(sample.Template)
+		tc.count++
+		tc.rows += sample.Rows
+		tc.queryHist.Record(sample.Query)
+		tc.httpHist.Record(sample.Elapse)
+		if sample.Endpoint != "" {
+			ec := c.endpoint(sample.Endpoint)
+			ec.selectCount++
+			ec.selectRows += sample.Rows
+			ec.selectHist.Record(sample.Elapse)
+		}
+	})
+}
+
+// recordAppend records a successful append request.
+func (stat *Stat) recordAppend(sample AppendSample) {
+	stat.record(func(c *StatCounters) {
+		c.appendCount++
+		c.appendAttempts++
+		c.appendRecords += sample.Records
+		c.appendBytes += sample.Bytes
+		c.appendRawBytes += sample.RawBytes
+		c.appendEncodeHist.Record(sample.Encode)
+		c.appendHttpHist.Record(sample.Elapse)
+		if sample.Endpoint != "" {
+			ec := c.endpoint(sample.Endpoint)
+			ec.appendCount++
+			ec.appendRecords += sample.Records
+			ec.appendHist.Record(sample.Elapse)
+		}
+	})
+}
+
+// recordError counts a failed attempt by its operation and category,
+// or a failed request.
+func (stat *Stat) recordError(sample errorSample) {
+	stat.record(func(c *StatCounters) {
+		if sample.failed {
+			switch sample.op {
+			case "select":
+				c.selectErrors++
+			case "append":
+				c.appendErrors++
+			}
+			return
+		}
+		c.errors[sample.op+"/"+sample.category]++
+		if sample.endpoint != "" {
+			if sample.op == "select" {
+				c.endpoint(sample.endpoint).selectErrors++
+			} else {
+				c.endpoint(sample.endpoint).appendErrors++
+			}
+		}
+		switch sample.op {
+		case "select":
+			c.selectAttempts++
+		case "append":
+			c.appendAttempts++
+		}
+		switch {
+		case sample.op == "select":
+			if sample.template != "" {
+				c.template(sample.template).errors++
+			}
+		case sample.op != "append":
+		case strings.HasPrefix(sample.category, "http "):
+			c.appendNon200++
+		case strings.HasPrefix(sample.category, "reason: "):
+			c.appendFail++
+		}
+	})
+}
+
+// recordQueue records the queueing delay or the miss of an open-loop request.
+func (stat *Stat) recordQueue(sample queueSample) {
+	stat.record(func(c *StatCounters) {
+		switch {
+		case sample.op == "select" && sample.missed:
+			c.selectMissed++
+		case sample.op == "select":
+			c.selectQueueHist.Record(sample.delay)
+		case sample.missed:
+			c.appendMissed++
+		default:
+			c.appendQueueHist.Record(sample.delay)
+		}
+	})
+}
+
+func (stat *Stat) recordVerify(sample VerifySample) {
+	stat.record(func(c *StatCounters) {
+		if sample.Skipped {
+			c.verifySkipped += sample.Rows
+			return
+		}
+		c.verifyRanges++
+		c.verifyRows += sample.Rows
+		c.verifyMissing += sample.Missing
+		c.verifyDuplicate += sample.Duplicate
+		c.verifyCorrupt += sample.Corrupt
+		if sample.Visible {
+			c.verifyDelayHist.Record(sample.Delay)
+		} else {
+			c.verifyInvisible++
+		}
+	})
+}
+
+func (stat *Stat) recordLag(sample LagSample) {
+	stat.record(func(c *StatCounters) {
+		if sample.Path == LagRollup {
+			c.lagRollupMarkers++
+			if sample.Visible {
+				c.lagRollupHist.Record(sample.Delay)
+			} else {
+				c.lagRollupInvisible++
+			}
+			return
+		}
+		c.lagRawMarkers++
+		if sample.Visible {
+			c.lagRawHist.Record(sample.Delay)
+		} else {
+			c.lagRawInvisible++
+		}
+	})
+}
+
+// startStage ends the current stage and starts the stage of the name.
+func (stat *Stat) startStage(name string) {
+	if st := stat.currentStage(); st != nil {
+		// a cycle does not span stages
+		st.end = time.Now()
+		stat.endCycle()
+	}
+	stat.stages = append(stat.stages, &stageStat{name: name, StatCounters: NewStatCounters()})
+}
+
+// record applies fn to the current cycle and the current stage.
+func (stat *Stat) record(fn func(c *StatCounters)) {
+	fn(stat.cycle)
+	if st := stat.currentStage(); st != nil {
+		fn(st.StatCounters)
+	}
+}
+
+// endCycle adds the current cycle to the cumulative statistics,
+// prints and writes it, then starts a new cycle.
+func (stat *Stat) endCycle() {
+	stat.cycle.end = time.Now()
+	stat.cumulative.Merge(stat.cycle)
+	stat.print()
+	if !stat.cycle.empty() {
+		stat.writeResult(stat.result("cycle", stat.currentStageName(), stat.cycle))
+	}
+	stat.sampleTelemetry(stat.cycle.end)
+	stat.cycle.Reset()
+}
+
+// sampleTelemetry samples the server views in the background with the time
+// of the cycle, so that a slow view does not hold the statistics.
+// The cycle is not sampled if the previous sample is still in progress.
+func (stat *Stat) sampleTelemetry(at time.Time) {
+	if stat.Telemetry == nil || stat.telemetryBusy {
+		return
+	}
+	stat.telemetryBusy = true
+	stat.telemetryWg.Add(1)
+	go func() {
+		defer stat.telemetryWg.Done()
+		stat.telemetryC <- stat.Telemetry.Sample(at)
+	}()
+}
+
+func (stat *Stat) writeTelemetry(sample *TelemetrySample) {
+	sample.Scenario, sample.Transport, sample.Format = stat.Scenario, stat.Transport, stat.Format
+	sample.Stage = stat.currentStageName()
+	printer.Printf("Telemetry at elapsed: %v\n", sample.Time.Sub(stat.createdTime))
+	sample.print()
+	for _, out := range stat.Outputs {
+		if err := out.WriteTelemetry(sample); err != nil {
+			fmt.Println("Failed to write telemetry:", err)
+		}
+	}
+}
+
+func (stat *Stat) Stop() {
+	stat.commandC <- "stop"
+	stat.wg.Wait()
+	close(stat.selectC)
+	close(stat.appendC)
+	close(stat.errorC)
+	close(stat.queueC)
+	close(stat.verifyC)
+	close(stat.lagC)
+	close(stat.stageC)
+	close(stat.commandC)
+	stat.print()
+	if !stat.cycle.empty() {
+		stat.writeResult(stat.result("cycle", stat.currentStageName(), stat.cycle))
+	}
+	if stat.Telemetry != nil {
+		// the sample in progress, then the last cycle
+		stat.telemetryWg.Wait()
+		select {
+		case sample := <-stat.telemetryC:
+			stat.writeTelemetry(sample)
+		default:
+		}
+		stat.writeTelemetry(stat.Telemetry.Sample(stat.cycle.end))
+	}
+	stat.printStages()
+	stat.printTemplates()
+	stat.printEndpoints()
+	for _, st := range stat.stages {
+		stat.writeResult(stat.result("stage", st.name, st.StatCounters))
+	}
+	stat.summary = stat.result("summary", "", stat.cumulative)
+	stat.writeResult(stat.summary)
+}
+
+// Summary returns the result of the whole run after Stop.
+func (stat *Stat) Summary() *Result {
+	return stat.summary
+}
+
+// SetStage starts statistics of the stage, the current cycle is
+// printed and reset so that a cycle belongs to only one stage.
+func (stat *Stat) SetStage(name string) {
+	stat.stageC <- name
+}
+
+type stageStat struct {
+	name string
+	*StatCounters
+}
+
+func (stat *Stat) currentStage() *stageStat {
+	if len(stat.stages) == 0 {
+		return nil
+	}
+	return stat.stages[len(stat.stages)-1]
+}
+
+func (stat *Stat) currentStageName() string {
+	if st := stat.currentStage(); st != nil {
+		return st.name
+	}
+	return ""
+}
+
+// result builds the record of the counters,
+// typ is "cycle", "stage" or "summary".
+func (stat *Stat) result(typ string, stage string, c *StatCounters) *Result {
+	sec := c.Duration().Seconds()
+	perSec := func(v int64) float64 {
+		if sec <= 0 {
+			return 0
+		}
+		return float64(v) / sec
+	}
+	r := &Result{
+		Time:          c.end,
+		Type:          typ,
+		Scenario:      stat.Scenario,
+		Transport:     stat.Transport,
+		Format:        stat.Format,
+		Seed:          stat.Seed,
+		Stage:         stage,
+		ElapsedSec:    time.Since(stat.createdTime).Seconds(),
+		DurationSec:   sec,
+		AppendWorkers: stat.AppendWorkers,
+		SelectWorkers: stat.SelectWorkers,
+		Select: SelectResult{
+			Count:    c.selectCount,
+			Rows:     c.selectRows,
+			Errors:   c.selectErrors,
+			Attempts: c.selectAttempts,
+			PerSec:   perSec(c.selectCount),
+			Http:     NewLatency(c.selectHttpHist),
+			Query:    NewLatency(c.selectQueryHist),
+			Missed:   c.selectMissed,
+			Queue:    NewLatency(c.selectQueueHist),
+		},
+		Append: AppendResult{
+			Count:         c.appendCount,
+			Errors:        c.appendErrors,
+			Attempts:      c.appendAttempts,
+			Records:       c.appendRecords,
+			Bytes:         c.appendBytes,
+			RawBytes:      c.appendRawBytes,
+			Non200:        c.appendNon200,
+			Fail:          c.appendFail,
+			PerSec:        perSec(c.appendCount),
+			RecordsPerSec: perSec(c.appendRecords),
+			BytesPerSec:   perSec(c.appendBytes),
+			Http:          NewLatency(c.appendHttpHist),
+			Encode:        NewLatency(c.appendEncodeHist),
+			Missed:        c.appendMissed,
+			Queue:         NewLatency(c.appendQueueHist),
+		},
+	}
+	if len(c.templates) > 1 {
+		for _, name := range c.templateNames() {
+			tc := c.templates[name]
+			r.Select.Templates = append(r.Select.Templates, TemplateResult{
+				Name:   name,
+				Count:  tc.count,
+				Rows:   tc.rows,
+				Errors: tc.errors,
+				PerSec: perSec(tc.count),
+				Http:   NewLatency(tc.httpHist),
+				Query:  NewLatency(tc.queryHist),
+			})
+		}
+	}
+	if len(c.endpoints) > 1 {
+		for _, addr := range c.endpointNames() {
+			ec := c.endpoints[addr]
+			r.Endpoints = append(r.Endpoints, EndpointResult{
+				Endpoint:      addr,
+				AppendCount:   ec.appendCount,
+				AppendRecords: ec.appendRecords,
+				AppendErrors:  ec.appendErrors,
+				AppendPerSec:  perSec(ec.appendCount),
+				AppendHttp:    NewLatency(ec.appendHist),
+				SelectCount:   ec.selectCount,
+				SelectRows:    ec.selectRows,
+				SelectErrors:  ec.selectErrors,
+				SelectPerSec:  perSec(ec.selectCount),
+				SelectHttp:    NewLatency(ec.selectHist),
+			})
+		}
+	}
+	if c.verifyRanges > 0 || c.verifySkipped > 0 {
+		r.Verify = &VerifyResult{
+			Ranges:    c.verifyRanges,
+			Rows:      c.verifyRows,
+			Missing:   c.verifyMissing,
+			Duplicate: c.verifyDuplicate,
+			Corrupt:   c.verifyCorrupt,
+			Invisible: c.verifyInvisible,
+			Skipped:   c.verifySkipped,
+			Delay:     NewLatency(c.verifyDelayHist),
+		}
+	}
+	if c.lagRawMarkers > 0 || c.lagRollupMarkers > 0 {
+		r.Lag = &LagResult{
+			Raw:    LagPathResult{Markers: c.lagRawMarkers, Invisible: c.lagRawInvisible, Delay: NewLatency(c.lagRawHist)},
+			Rollup: LagPathResult{Markers: c.lagRollupMarkers, Invisible: c.lagRollupInvisible, Delay: NewLatency(c.lagRollupHist)},
+		}
+	}
+	if len(c.errors) > 0 {
+		r.Errors = map[string]int64{}
+		for k, v := range c.errors {
+			r.Errors[k] = v
+		}
+	}
+	return r
+}
+
+func (stat *Stat) writeResult(r *Result) {
+	for _, out := range stat.Outputs {
+		if err := out.Write(r); err != nil {
+			fmt.Println("Failed to write result:", err)
+		}
+	}
+}
+
+// PrintComparison prints the summaries of the runs of the same scenario
+// over different transports and payload formats side by side.
+func PrintComparison(results []*Result) {
+	printer.Printf("%-10s %-12s %10s %10s %10s %8s %12s %12s %12s %10s %12s %8s\n",
+		"Transport", "Format", "append/s", "records/s", "bytes/rec", "ratio", "encode-avg",
+		"append-p50", "append-p99", "select/s", "select-p99", "errors")
+	for _, r := range results {
+		var errs int64
+		for _, v := range r.Errors {
+			errs += v
+		}
+		bytesPerRecord, ratio := 0.0, 0.0
+		if r.Append.Records > 0 {
+			bytesPerRecord = float64(r.Append.Bytes) / float64(r.Append.Records)
+		}
+		if r.Append.RawBytes > 0 {
+			ratio = float64(r.Append.Bytes) / float64(r.Append.RawBytes)
+		}
+		printer.Printf("%-10s %-12s %10.1f %10.1f %10.1f %8.2f %12s %12s %12s %10.1f %12s %8d\n",
+			r.Transport, r.Format, r.Append.PerSec, r.Append.RecordsPerSec, bytesPerRecord, ratio,
+			formatMs(r.Append.Encode.Avg), formatMs(r.Append.Http.P50), formatMs(r.Append.Http.P99),
+			r.Select.PerSec, formatMs(r.Select.Http.P99), errs)
+	}
+	if slices.ContainsFunc(results, func(r *Result) bool { return r.Transport == TransportNative }) {
+		printer.Println("append-p50 and append-p99 of native are the hand-off to the Appender, not the response of the server")
+	}
+	printer.Println()
+}
+
+func formatMs(ms float64) string {
+	return (time.Duration(ms * float64(time.Millisecond))).Round(time.Microsecond).String()
+}
+
+// printStages prints the achieved throughput and latency of every stage.
+func (stat *Stat) printStages() {
+	if len(stat.stages) == 0 {
+		return
+	}
+	printer.Printf("%-16s %10s %10s %10s %12s %10s %12s %8s\n",
+		"Stage", "duration", "append/s", "records/s", "append-p99", "select/s", "select-p99", "errors")
+	for _, st := range stat.stages {
+		sec := st.Duration().Seconds()
+		if sec <= 0 {
+			continue
+		}
+		printer.Printf("%-16s %10v %10.1f %10.1f %12v %10.1f %12v %8d\n",
+			st.name, st.Duration().Round(time.Second),
+			float64(st.appendCount)/sec, float64(st.appendRecords)/sec,
+			st.appendHttpHist.Percentile(99).Round(time.Microsecond),
+			float64(st.selectCount)/sec,
+			st.selectHttpHist.Percentile(99).Round(time.Microsecond),
+			st.ErrorCount())
+	}
+	printer.Println()
+}
+
+// printTemplates prints the select statistics of every query template of the run.
+func (stat *Stat) printTemplates() {
+	total := stat.cumulative
+	if len(total.templates) < 2 {
+		return
+	}
+	sec := total.Duration().Seconds()
+	printer.Printf("%-16s %10s %10s %8s %10s %12s %12s %12s\n",
+		"Template", "count", "select/s", "errors", "rows", "http-p50", "http-p99", "query-p99")
+	for _, name := range total.templateNames() {
+		tc := total.templates[name]
+		printer.Printf("%-16s %10d %10.1f %8d %10d %12v %12v %12v\n",
+			name, tc.count, float64(tc.count)/sec, tc.errors, tc.rows,
+			tc.httpHist.Percentile(50).Round(time.Microsecond),
+			tc.httpHist.Percentile(99).Round(time.Microsecond),
+			tc.queryHist.Percentile(99).Round(time.Microsecond))
+	}
+	printer.Println()
+}
+
+// printEndpoints prints the statistics of every http endpoint of the run.
+func (stat *Stat) printEndpoints() {
+	total := stat.cumulative
+	if len(total.endpoints) < 2 {
+		return
+	}
+	sec := total.Duration().Seconds()
+	printer.Printf("%-28s %10s %8s %12s %10s %8s %12s\n",
+		"Endpoint", "append/s", "errors", "append-p99", "select/s", "errors", "select-p99")
+	for _, addr := range total.endpointNames() {
+		ec := total.endpoints[addr]
+		printer.Printf("%-28s %10.1f %8d %12v %10.1f %8d %12v\n",
+			addr, float64(ec.appendCount)/sec, ec.appendErrors, ec.appendHist.Percentile(99).Round(time.Microsecond),
+			float64(ec.selectCount)/sec, ec.selectErrors, ec.selectHist.Percentile(99).Round(time.Microsecond))
+	}
+	printer.Println()
+}
+
+// appendLatencyName is the label of the append latency, over native it is
+// the hand-off to the Appender, which sends the records in the background.
+func (stat *Stat) appendLatencyName() string {
+	if stat.Transport == TransportNative {
+		return "handoff"
+	}
+	return "http"
+}
+
+func (stat *Stat) Print() {
+	stat.commandC <- "print"
+}
+
+func (stat *Stat) PrintAndReset() {
+	stat.commandC <- "print-reset"
+}
+
+var printer = message.NewPrinter(language.English)
+
+func (stat *Stat) print() {
+	cycle, total := stat.cycle, stat.cumulative
+	if cycle.empty() {
+		return
+	}
+	if st := stat.currentStage(); st != nil {
+		printer.Printf("Elapsed: %v Stage: %s\n", time.Since(stat.createdTime), st.name)
+	} else {
+		printer.Printf("Elapsed: %v\n", time.Since(stat.createdTime))
+	}
+	if cycle.selectAttempts > 0 {
+		printer.Printf("Cumulative select: %d error: %d attempts: %d rows: %d\n",
+			total.selectCount, total.selectErrors, total.selectAttempts, total.selectRows)
+		printPercentiles("http", total.selectHttpHist)
+		printPercentiles("query", total.selectQueryHist)
+		printer.Printf("This cycle select: %d error: %d attempts: %d rows: %d\n",
+			cycle.selectCount, cycle.selectErrors, cycle.selectAttempts, cycle.selectRows)
+		printer.Printf("         http-avg: %v http-min: %v http-max: %v\n",
+			cycle.selectHttpHist.Mean(), cycle.selectHttpHist.Min(), cycle.selectHttpHist.Max())
+		printer.Printf("        query-avg: %v query-min: %v query-max: %v\n",
+			cycle.selectQueryHist.Mean(), cycle.selectQueryHist.Min(), cycle.selectQueryHist.Max())
+		printPercentiles("http", cycle.selectHttpHist)
+		printPercentiles("query", cycle.selectQueryHist)
+		if len(total.templates) > 1 {
+			for _, name := range cycle.templateNames() {
+				tc := cycle.templates[name]
+				printer.Printf("%13s: %d error: %d rows: %d http-p50: %v http-p99: %v query-p99: %v\n",
+					name, tc.count, tc.errors, tc.rows, tc.httpHist.Percentile(50),
+					tc.httpHist.Percentile(99), tc.queryHist.Percentile(99))
+			}
+		}
+	}
+	if cycle.appendAttempts > 0 {
+		printer.Printf("Cumulative append: %d error: %d attempts: %d records: %d bytes: %d non-200: %d fail: %d\n",
+			total.appendCount, total.appendErrors, total.appendAttempts, total.appendRecords, total.appendBytes,
+			total.appendNon200, total.appendFail)
+		latency := stat.appendLatencyName()
+		printPercentiles(latency, total.appendHttpHist)
+		cycleSec := cycle.Duration().Seconds()
+		printer.Printf("This cycle append: %d error: %d attempts: %d records: %d bytes: %d non-200: %d fail: %d\n",
+			cycle.appendCount, cycle.appendErrors, cycle.appendAttempts, cycle.appendRecords, cycle.appendBytes,
+			cycle.appendNon200, cycle.appendFail)
+		printer.Printf("        records/s: %.1f bytes/s: %.1f\n",
+			float64(cycle.appendRecords)/cycleSec, float64(cycle.appendBytes)/cycleSec)
+		if cycle.appendRawBytes != cycle.appendBytes {
+			printer.Printf("      raw-bytes/s: %.1f ratio: %.2f\n",
+				float64(cycle.appendRawBytes)/cycleSec, float64(cycle.appendBytes)/float64(cycle.appendRawBytes))
+		}
+		printer.Printf("       encode-avg: %v encode-max: %v\n",
+			cycle.appendEncodeHist.Mean(), cycle.appendEncodeHist.Max())
+		printer.Printf("%13s-avg: %v %s-min: %v %s-max: %v\n",
+			latency, cycle.appendHttpHist.Mean(), latency, cycle.appendHttpHist.Min(), latency, cycle.appendHttpHist.Max())
+		printPercentiles(latency, cycle.appendHttpHist)
+	}
+	if len(total.endpoints) > 1 {
+		for _, addr := range cycle.endpointNames() {
+			ec := cycle.endpoints[addr]
+			printer.Printf("Endpoint %s append: %d error: %d http-p99: %v select: %d error: %d http-p99: %v\n",
+				addr, ec.appendCount, ec.appendErrors, ec.appendHist.Percentile(99),
+				ec.selectCount, ec.selectErrors, ec.selectHist.Percentile(99))
+		}
+	}
+	if total.appendQueueHist.Count() > 0 || total.selectQueueHist.Count() > 0 {
+		printer.Printf("Cumulative schedule append missed: %d select missed: %d\n",
+			total.appendMissed, total.selectMissed)
+		if total.appendQueueHist.Count() > 0 {
+			printPercentiles("append-queue", total.appendQueueHist)
+		}
+		if total.selectQueueHist.Count() > 0 {
+			printPercentiles("select-queue", total.selectQueueHist)
+		}
+		printer.Printf("This cycle schedule append missed: %d select missed: %d\n",
+			cycle.appendMissed, cycle.selectMissed)
+		if cycle.appendQueueHist.Count() > 0 {
+			printPercentiles("append-queue", cycle.appendQueueHist)
+		}
+		if cycle.selectQueueHist.Count() > 0 {
+			printPercentiles("select-queue", cycle.selectQueueHist)
+		}
+	}
+	if total.verifyRanges > 0 || total.verifySkipped > 0 {
+		printer.Printf("Cumulative verify: %d rows: %d missing: %d duplicate: %d corrupt: %d invisible: %d skipped: %d\n",
+			total.verifyRanges, total.verifyRows, total.verifyMissing, total.verifyDuplicate,
+			total.verifyCorrupt, total.verifyInvisible, total.verifySkipped)
+		printPercentiles("visible", total.verifyDelayHist)
+		printer.Printf("This cycle verify: %d rows: %d missing: %d duplicate: %d corrupt: %d invisible: %d skipped: %d\n",
+			cycle.verifyRanges, cycle.verifyRows, cycle.verifyMissing, cycle.verifyDuplicate,
+			cycle.verifyCorrupt, cycle.verifyInvisible, cycle.verifySkipped)
+		printPercentiles("visible", cycle.verifyDelayHist)
+	}
+	if total.lagRawMarkers > 0 || total.lagRollupMarkers > 0 {
+		printer.Printf("Cumulative lag raw: %d invisible: %d rollup: %d invisible: %d\n",
+			total.lagRawMarkers, total.lagRawInvisible, total.lagRollupMarkers, total.lagRollupInvisible)
+		printPercentiles("raw", total.lagRawHist)
+		if total.lagRollupMarkers > 0 {
+			printPercentiles("rollup", total.lagRollupHist)
+		}
+		printer.Printf("This cycle lag raw: %d invisible: %d rollup: %d invisible: %d\n",
+			cycle.lagRawMarkers, cycle.lagRawInvisible, cycle.lagRollupMarkers, cycle.lagRollupInvisible)
+		printPercentiles("raw", cycle.lagRawHist)
+		if total.lagRollupMarkers > 0 {
+			printPercentiles("rollup", cycle.lagRollupHist)
+		}
+	}
+	if len(total.errors) > 0 {
+		printErrors("Cumulative errors:", total.errors)
+		printErrors("This cycle errors:", cycle.errors)
+	}
+	printer.Println()
+}
+
+// printPercentiles prints a line like "         http-p50: 1ms http-p90: 2ms ..."
+func printPercentiles(name string, h *Histogram) {
+	for i, p := range Percentiles {
+		if i == 0 {
+			printer.Printf("%13s", name)
+		} else {
+			printer.Printf(" %s", name)
+		}
+		printer.Printf("-p%v: %v", p, h.Percentile(p))
+	}
+	printer.Println()
+}
+
+// printErrors prints the error counts sorted by "op/category".
+func printErrors(title string, errs map[string]int64) {
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	printer.Printf("%s", title)
+	for i, k := range keys {
+		if i > 0 {
+			printer.Printf(",")
+		}
+		printer.Printf(" %s: %d", k, errs[k])
+	}
+	printer.Println()
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,20 +11,21 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/tidwall/gjson"
-	"golang.org/x/text/language"
-	"golang.org/x/text/message"
 )
 
 func main() {
 	var scenarioName string
 	var neoHttpAddr string
+	var endpointPolicy string
 	var cleanStart bool
 	var cleanStop bool
 	var slowQueryThreshold time.Duration
@@ -30,9 +33,43 @@ func main() {
 	var overrideTimeout time.Duration
 	var overrideAppender int
 	var overrideSelector int
-
-	flag.StringVar(&scenarioName, "scenario", "default", "scenario name")
-	flag.StringVar(&neoHttpAddr, "neo-http", "http://127.0.0.1:5654", "machbase-neo http address")
+	var onError string
+	var maxRetry int
+	var retryBackoff time.Duration
+	var maxErrorRate string
+	var openLoop bool
+	var maxInflight int
+	var stages string
+	var outJson string
+	var outCsv string
+	var metricsAddr string
+	var verify bool
+	var verifyWorkers int
+	var verifyEvery int
+	var verifyTimeout time.Duration
+	var transportList string
+	var neoNativeAddr string
+	var neoUser string
+	var neoPassword string
+	var formatList string
+	var drainTimeout time.Duration
+	var telemetryList string
+	var findCapacity string
+	var sloP99 time.Duration
+	var sloErrorRateStr string
+	var probeDuration time.Duration
+	var capacityMin float64
+	var capacityMax float64
+	var capacityPrecision string
+	var capacityProbes int
+	var lagInterval time.Duration
+	var lagTimeout time.Duration
+	var lagRollupFlag string
+	var seed int64
+
+	flag.StringVar(&scenarioName, "scenario", "default", "bundled scenario name or path to a scenario file")
+	flag.StringVar(&neoHttpAddr, "neo-http", "http://127.0.0.1:5654", "machbase-neo http address, a comma separated list spreads the requests over the nodes by -endpoint-policy")
+	flag.StringVar(&endpointPolicy, "endpoint-policy", EndpointRoundRobin, "assignment of the requests to the -neo-http endpoints: round-robin, random or sticky per worker")
 	flag.BoolVar(&cleanStart, "clean-start", false, "drop table before start")
 	flag.BoolVar(&cleanStop, "clean-stop", false, "drop table after stop")
 	flag.DurationVar(&slowQueryThreshold, "slow-query", 0, "slow query threshold time duration")
@@ -40,16 +77,90 @@ func main() {
 	flag.DurationVar(&overrideTimeout, "timeout", 0, "override timeout of the scenario")
 	flag.IntVar(&overrideAppender, "append", -1, "override append worker count")
 	flag.IntVar(&overrideSelector, "select", -1, "override select worker count")
+	flag.StringVar(&onError, "on-error", OnErrorAbort, "error policy: abort, count or retry")
+	flag.IntVar(&maxRetry, "max-retry", 3, "max retry count of a failed request when -on-error retry")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 100*time.Millisecond, "initial backoff of retries, doubled every retry")
+	flag.StringVar(&maxErrorRate, "max-error-rate", "", "error budget, stop the run when the error rate exceeds it (e.g. 1%)")
+	flag.BoolVar(&openLoop, "open-loop", false, "issue requests at a constant arrival rate regardless of the in-flight requests")
+	flag.IntVar(&maxInflight, "max-inflight", 1000, "max concurrent requests per append and select in -open-loop mode")
+	flag.StringVar(&stages, "stages", "", "load profile overriding the stages of the scenario, [name=]duration:shape:append_rate:select_rate,... of the shapes step (or hold), ramp and spike")
+	flag.StringVar(&outJson, "out", "", "write the result of every cycle and the summary to the file as JSON lines")
+	flag.StringVar(&outCsv, "out-csv", "", "write the result of every cycle and the summary to the file as CSV")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics of the run at http://<addr>/metrics (e.g. :9100)")
+	flag.BoolVar(&verify, "verify", false, "read back the appended records and report missing, duplicated and corrupted rows")
+	flag.IntVar(&verifyWorkers, "verify-workers", 4, "verifier worker count of -verify")
+	flag.IntVar(&verifyEvery, "verify-every", 1, "verify one of every N append requests of -verify")
+	flag.DurationVar(&verifyTimeout, "verify-timeout", 10*time.Second, "how long the appended records are polled until they are visible")
+	flag.StringVar(&transportList, "transport", TransportHttp, "http or native, a comma separated list runs the scenario over each and compares them")
+	flag.StringVar(&neoNativeAddr, "neo-native", "127.0.0.1:5656", "machbase-neo native address of -transport native")
+	flag.StringVar(&neoUser, "user", "sys", "user of -transport native")
+	flag.StringVar(&neoPassword, "password", "manager", "password of -transport native")
+	flag.StringVar(&formatList, "append-format", PayloadCSV, "append payload csv, json or ndjson with optional +gzip, a comma separated list runs the scenario with each and compares them")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 10*time.Second, "how long in-flight requests are waited for when the run stops")
+	flag.StringVar(&telemetryList, "telemetry", "", "server views sampled every cycle over -neo-http, a comma separated list of mutex, session, storage, rollup or the views of the scenario")
+	flag.StringVar(&findCapacity, "find-capacity", "", "search the highest append or select rate that passes the SLO of -slo-p99 and -slo-error-rate")
+	flag.DurationVar(&sloP99, "slo-p99", 200*time.Millisecond, "p99 latency SLO of -find-capacity, 0 means no latency SLO")
+	flag.StringVar(&sloErrorRateStr, "slo-error-rate", "1%", "error rate SLO of -find-capacity")
+	flag.DurationVar(&probeDuration, "probe-duration", 30*time.Second, "duration of a probe of -find-capacity")
+	flag.Float64Var(&capacityMin, "capacity-min", 10, "rate of the first probe of -find-capacity, requests per second")
+	flag.Float64Var(&capacityMax, "capacity-max", 0, "max rate of -find-capacity, 0 means no limit")
+	flag.StringVar(&capacityPrecision, "capacity-precision", "5%", "-find-capacity stops when the gap between the passed and the failed rates is within it")
+	flag.IntVar(&capacityProbes, "capacity-probes", 12, "max probes of -find-capacity")
+	flag.DurationVar(&lagInterval, "lag-probe", 0, "write a marker record every interval and report how long until it is visible in the raw table and the rollup, 0 disables it")
+	flag.DurationVar(&lagTimeout, "lag-timeout", 5*time.Minute, "how long a marker of -lag-probe is polled until it is visible")
+	flag.StringVar(&lagRollupFlag, "lag-rollup", "auto", "rollup unit of -lag-probe: sec, min, hour, none or auto from WITH ROLLUP of the scenario table")
+	flag.Int64Var(&seed, "seed", 0, "seed of the generated data and queries, every worker draws from its own RNG derived from it; 0 picks a random seed, printed to replay the run")
 	flag.Parse()
 
-	if scenario, ok := scenarios[scenarioName]; !ok {
-		fmt.Printf("scenario %q not found\n", scenarioName)
+	policy, err := ParseErrorPolicy(onError, maxRetry, retryBackoff, maxErrorRate)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	transports, err := ParseTransports(transportList)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	formats, err := ParsePayloadFormats(formatList)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	endpoints, err := ParseEndpoints(neoHttpAddr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if endpointPolicy, err = ParseEndpointPolicy(endpointPolicy); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	capacityOp := ""
+	if findCapacity != "" {
+		if capacityOp, err = ParseCapacityOp(findCapacity); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if scenario, err := LoadScenario(scenarioName); err != nil {
+		fmt.Println(err)
 		fmt.Printf("available scenarios:\n")
-		for name := range scenarios {
+		for _, name := range BundledScenarioNames() {
 			fmt.Printf("  %s\n", name)
 		}
 		return
 	} else {
+		if stages != "" {
+			if scenario.Stages, err = ParseStages(stages); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if len(scenario.Stages) > 0 {
+			scenario.Timeout = scenario.Stages.Duration()
+		}
 		if overrideTimeout > 0 {
 			scenario.Timeout = overrideTimeout
 		}
@@ -67,329 +178,723 @@ func main() {
 		fmt.Println("CPU count:", runtime.NumCPU())
 		fmt.Println("Append worker:", scenario.AppendWorker)
 		fmt.Println("Select worker:", scenario.SelectWorker)
+		fmt.Println("On error:", policy.Mode)
+		if seed == 0 {
+			seed = NewSeed()
+		}
+		fmt.Println("Seed:", seed)
+		fmt.Println("Transport:", strings.Join(transports, ", "))
+		if len(endpoints) > 1 {
+			fmt.Printf("Endpoints: %s (%s)\n", strings.Join(endpoints, ", "), endpointPolicy)
+		}
+		fmt.Println("Append format:", formatList)
+		for _, f := range formats {
+			if f.Name != PayloadCSV && slices.Contains(scenario.AppendColumns, "") {
+				fmt.Printf("Scenario %s can not append %s, the columns need names\n", scenarioName, f)
+				os.Exit(1)
+			}
+		}
+		if telemetryList == "" {
+			for _, v := range scenario.Telemetry {
+				telemetryList += "," + v.Name
+			}
+		}
+		telemetry, err := ParseTelemetry(telemetryList, scenario.Telemetry)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(telemetry) > 0 {
+			names := []string{}
+			for _, v := range telemetry {
+				names = append(names, v.Name)
+			}
+			fmt.Println("Telemetry:", strings.Join(names, ", "))
+		}
+		if verify {
+			if scenario.VerifyTarget == nil {
+				fmt.Printf("Scenario %s can not be verified: %v\n", scenarioName, scenario.verifyErr)
+				os.Exit(1)
+			}
+			if err := scenario.VerifyTarget.Verifiable(); err != nil {
+				fmt.Printf("Scenario %s can not be verified: %v\n", scenarioName, err)
+				os.Exit(1)
+			}
+			vt := scenario.VerifyTarget
+			fmt.Printf("Verify: %s(%s, %s, %s) workers %d every %d timeout %v\n",
+				vt.Table, vt.TagColumn, vt.TimeColumn, vt.ValueColumn, verifyWorkers, verifyEvery, verifyTimeout)
+		}
+		lagRollup := ""
+		if lagInterval > 0 {
+			if scenario.VerifyTarget == nil || scenario.AppendRecordDataFunc == nil {
+				fmt.Printf("Scenario %s can not be probed for lag: %v\n", scenarioName, scenario.verifyErr)
+				os.Exit(1)
+			}
+			if lagRollup, err = ParseLagRollup(lagRollupFlag, scenario.CreateTableSql); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			vt := scenario.VerifyTarget
+			fmt.Printf("Lag probe: %s(%s, %s) every %v timeout %v rollup %q\n",
+				vt.Table, vt.TagColumn, vt.TimeColumn, lagInterval, lagTimeout, lagRollup)
+		}
+		for _, st := range scenario.Stages {
+			fmt.Printf("Stage %s: %v %s append %.1f/s select %.1f/s\n",
+				st.Name, st.Duration, st.Shape, st.AppendRate, st.SelectRate)
+		}
+		if openLoop && len(scenario.Stages) == 0 {
+			fmt.Println("Open loop append rate:", scenario.AppendWorker*scenario.AppendWorkerRunPerSecond*appendSplit, "/s")
+			fmt.Println("Open loop select rate:", scenario.SelectWorker*scenario.SelectWorkerRunPerSecond, "/s")
+			fmt.Println("Max inflight:", maxInflight)
+		}
 		fmt.Println()
-		start := time.Now()
-		scenario.Run(neoHttpAddr, cleanStart, cleanStop, httpTimeout, slowQueryThreshold)
-		fmt.Println("Total time:", time.Since(start))
-	}
-}
-
-var scenarios = map[string]Scenario{
-	"default": {
-		CreateTableSql: `CREATE TAG TABLE IF NOT EXISTS test_table (
-			name varchar(40) primary key,
-			time datetime basetime,
-			value double summarized)
-		`,
-		DropTableSql:             `DROP TABLE test_table`,
-		AppendUri:                "/db/write/test_table?method=append",
-		AppendWorker:             10,
-		AppendWorkerRunPerSecond: 10,
-		AppendRecordsPerRun:      10,
-		AppendRecordDataFunc: func(workerId int, now time.Time, round int, nth int, w io.Writer) {
-			fmt.Fprintf(w, "tag_%d_%d,%d,%f\n", workerId, nth, time.Now().UnixNano(), rand.Float64())
-		},
-		SelectWorker:             20,
-		SelectWorkerRunPerSecond: 10,
-		SelectSqlFunc: func(workerId int, now time.Time) string {
-			return fmt.Sprintf("SELECT * FROM test_table WHERE time > %d limit 100", now.Add(-10*time.Second).UnixNano())
-
-		},
-	},
-	"rollup": {
-		CreateTableSql: `CREATE TAG TABLE IF NOT EXISTS test_table (
-			name varchar(40) primary key,
-			time datetime basetime,
-			value double summarized) with rollup(MIN)
-		`,
-		DropTableSql:             `DROP TABLE test_table CASCADE`,
-		AppendUri:                "/db/write/test_table?method=append",
-		AppendWorker:             2,
-		AppendWorkerRunPerSecond: 10,
-		AppendRecordsPerRun:      1000,
-		AppendRecordDataFunc: func(workerId int, now time.Time, round int, nth int, w io.Writer) {
-			fmt.Fprintf(w, "tag_%d_%d,%d,%f\n", workerId, nth, time.Now().UnixNano(), rand.Float64())
-		},
-		SelectWorker:             runtime.NumCPU() - 2,
-		SelectWorkerRunPerSecond: 1000,
-		SelectSqlFunc: func(workerId int, now time.Time) string {
-			return fmt.Sprintf("select name, rollup('min', 1, time) mtime, avg(value) from test_table where time >= %d group by name, mtime limit 20",
-				now.Add(-10*time.Minute).UnixNano())
-		},
-	},
-	"rollup-meta": {
-		CreateTableSql: `CREATE TAG TABLE IF NOT EXISTS test_table (
-			name varchar(40) primary key,
-			time datetime basetime,
-			value double summarized) 
-            metadata (worker varchar(20), nth int32) with rollup(MIN)
-		`,
-		DropTableSql:             `DROP TABLE test_table CASCADE`,
-		AppendUri:                "/db/write/test_table?method=append",
-		AppendWorker:             2,
-		AppendWorkerRunPerSecond: 10,
-		AppendRecordsPerRun:      1000,
-		AppendRecordDataFunc: func(workerId int, now time.Time, nRun int, nRecord int, w io.Writer) {
-			ts := time.Now()
-			if workerId == 0 && nRecord >= 900 {
-				// these sensors have wrong internal clock and send data 1 minute ago
-				ts = ts.Add(-1 * time.Minute)
+		outputs := []ResultWriter{}
+		if outJson != "" {
+			w, err := NewJSONResultWriter(outJson)
+			if err != nil {
+				fmt.Println("Failed to create result file:", err)
+				os.Exit(1)
 			}
-			// name, time, value, worker, round
-			workerId = workerId*500 + nRun%500
-			fmt.Fprintf(w, "tag_%d_%d,%d,%f,worker-%d,%d\n",
-				workerId, nRecord, ts.UnixNano(), rand.Float64(), workerId, nRecord)
-		},
-		SelectWorker:             runtime.NumCPU() * 2,
-		SelectWorkerRunPerSecond: 1000,
-		SelectSqlFunc: func(workerId int, now time.Time) string {
-			ts := time.Now()
-			targetNRecord := rand.Int31n(1000)
-			workers := []string{}
-			for i := 0; i < 8; i++ {
-				workers = append(workers, fmt.Sprintf("'worker-%d'", rand.Int31n(1000)))
+			outputs = append(outputs, w)
+		}
+		if outCsv != "" {
+			w, err := NewCSVResultWriter(outCsv)
+			if err != nil {
+				fmt.Println("Failed to create result file:", err)
+				os.Exit(1)
 			}
-			return fmt.Sprintf(`select
-                    name, rollup('min', 1, time) mtime, avg(value)
-                from
-                    test_table
-                where
-			        worker in (%s)
-                and nth = %d
-                and time >= TO_DATE('%s') and time <= TO_DATE('%s')
-                group by name, mtime`,
-				strings.Join(workers, ","),
-				targetNRecord,
-				ts.Add(-2*time.Minute).In(time.Local).Format("2006-01-02 15:04:05"), ts.In(time.Local).Format("2006-01-02 15:04:05"))
-		},
-	},
-	"part1": {
-		CreateTableSql: `CREATE TAG TABLE IF NOT EXISTS test_table (
-				tagid   VARCHAR(12) PRIMARY KEY,
-				time    DATETIME BASETIME,
-				value   DOUBLE SUMMARIZED,
-				value1  DOUBLE,
-				value2  DOUBLE
-			)
-			METADATA
-			(
-				meta1    VARCHAR(32),
-				meta2    VARCHAR(32)
-			) tag_partition_count=1, tag_data_part_size=33554432`,
-		DropTableSql:             `DROP TABLE test_table CASCADE`,
-		AppendUri:                "/db/write/test_table?method=append",
-		AppendWorker:             2,
-		AppendWorkerRunPerSecond: 10,
-		AppendRecordsPerRun:      1000,
-		AppendRecordDataFunc: func(workerId int, now time.Time, nRun int, nRecord int, w io.Writer) {
-			ts := time.Now()
-			tagId := rand.Int31n(1_000_000)
-			m1 := tagId % 200
-			m2 := m1 % 100
-			fmt.Fprintf(w, "tag-%d,%d,%f,%f,%f,m1-%d,m2-%d\n",
-				tagId, ts.UnixNano(), rand.Float64(), rand.Float64(), rand.Float64(), m1, m2)
-		},
-		SelectWorker:             runtime.NumCPU() * 2,
-		SelectWorkerRunPerSecond: 1000,
-		SelectSqlFunc: func(workerId int, now time.Time) string {
-			m1s := []string{}
-			m2s := []string{}
-			for i := 0; i < 4; i++ {
-				tagId := rand.Int31n(1_000_000)
-				m1 := tagId % 200
-				if i < 2 {
-					m1s = append(m1s, fmt.Sprintf("'m1-%d'", m1))
+			outputs = append(outputs, w)
+		}
+		var metrics *Metrics
+		if metricsAddr != "" {
+			metrics = NewMetrics(scenario.Name, scenario.AppendWorker, scenario.SelectWorker)
+			svr, err := ServeMetrics(metricsAddr, metrics)
+			if err != nil {
+				fmt.Println("Failed to serve metrics:", err)
+				os.Exit(1)
+			}
+			defer svr.Close()
+			fmt.Printf("Metrics: http://%s/metrics\n\n", metricsAddr)
+		}
+		// the formats apply to http, native appends the records as they are
+		type runSpec struct {
+			transport string
+			format    PayloadFormat
+		}
+		runs := []runSpec{}
+		for _, transport := range transports {
+			if transport == TransportNative {
+				runs = append(runs, runSpec{transport: transport})
+				continue
+			}
+			for _, format := range formats {
+				runs = append(runs, runSpec{transport: transport, format: format})
+			}
+		}
+		var capacity CapacitySearch
+		capacityErrorMode := onError
+		if capacityOp != "" {
+			if capacityErrorMode == OnErrorAbort {
+				capacityErrorMode = OnErrorCount
+			}
+			sloErrorRate, err := parseRate(sloErrorRateStr)
+			if err != nil {
+				fmt.Printf("invalid -slo-error-rate %q\n", sloErrorRateStr)
+				os.Exit(1)
+			}
+			precision, err := parseRate(capacityPrecision)
+			if err != nil || precision <= 0 {
+				fmt.Printf("invalid -capacity-precision %q\n", capacityPrecision)
+				os.Exit(1)
+			}
+			if capacityMin <= 0 || probeDuration <= 0 {
+				fmt.Println("-capacity-min and -probe-duration should be positive")
+				os.Exit(1)
+			}
+			capacity = CapacitySearch{
+				Op:            capacityOp,
+				SLO:           SLO{P99: sloP99, ErrorRate: sloErrorRate},
+				ProbeDuration: probeDuration,
+				Min:           capacityMin,
+				Max:           capacityMax,
+				Precision:     precision,
+				MaxProbes:     capacityProbes,
+			}
+			fmt.Printf("Find capacity of %s: SLO %s, probes of %v from %.1f/s\n\n", capacityOp, capacity.SLO, probeDuration, capacityMin)
+		}
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		interrupted := false
+		results := []*Result{}
+		for i, run := range runs {
+			if len(runs) > 1 {
+				fmt.Printf("Run %d/%d: transport %s format %s\n\n", i+1, len(runs), run.transport, run.format)
+			}
+			// every run has its own error budget
+			policy, _ := ParseErrorPolicy(onError, maxRetry, retryBackoff, maxErrorRate)
+			start := time.Now()
+			runOpts := RunOptions{
+				NeoHttpAddr:        endpoints[0],
+				Endpoints:          endpoints,
+				EndpointPolicy:     endpointPolicy,
+				NeoNativeDSN:       fmt.Sprintf("server=tcp://%s:%s@%s", neoUser, neoPassword, neoNativeAddr),
+				Transport:          run.transport,
+				Format:             run.format,
+				CleanStart:         cleanStart,
+				CleanStop:          cleanStop,
+				HttpTimeout:        httpTimeout,
+				SlowQueryThreshold: slowQueryThreshold,
+				ErrorPolicy:        policy,
+				OpenLoop:           openLoop,
+				MaxInflight:        maxInflight,
+				Outputs:            outputs,
+				Metrics:            metrics,
+				Verify:             verify,
+				VerifyWorkers:      verifyWorkers,
+				VerifyEvery:        verifyEvery,
+				VerifyTimeout:      verifyTimeout,
+				Interrupt:          interrupt,
+				DrainTimeout:       drainTimeout,
+				Telemetry:          telemetry,
+				LagInterval:        lagInterval,
+				LagTimeout:         lagTimeout,
+				LagRollup:          lagRollup,
+				Seed:               seed,
+			}
+			if capacityOp != "" {
+				probes, err := scenario.FindCapacity(capacity, runOpts, func() *ErrorPolicy {
+					// the error rate SLO judges the errors, a probe is not aborted by the first one
+					p, _ := ParseErrorPolicy(capacityErrorMode, maxRetry, retryBackoff, maxErrorRate)
+					return p
+				})
+				fmt.Println("Total time:", time.Since(start))
+				PrintCapacity(capacity, probes, scenario.AppendRecordsPerRun/appendSplit)
+				if errors.Is(err, ErrInterrupted) {
+					interrupted = true
+					break
+				}
+				if err != nil {
+					for _, out := range outputs {
+						out.Close()
+					}
+					fmt.Println("Aborted:", err)
+					os.Exit(1)
 				}
-				m2 := m1 % 100
-				m2s = append(m2s, fmt.Sprintf("'m2-%d'", m2))
+				continue
 			}
-			return fmt.Sprintf(`select * from test_table
-				where
-					meta1 in (%s)
-				and meta2 in (%s)
-				order by time desc
-				limit 10`,
-				strings.Join(m1s, ","),
-				strings.Join(m2s, ","))
-		},
-	},
+			result, err := scenario.Run(runOpts)
+			fmt.Println("Total time:", time.Since(start))
+			if errors.Is(err, ErrInterrupted) {
+				// the remaining runs are skipped
+				results = append(results, result)
+				interrupted = true
+				break
+			}
+			if err != nil {
+				for _, out := range outputs {
+					out.Close()
+				}
+				fmt.Println("Aborted:", err)
+				os.Exit(1)
+			}
+			results = append(results, result)
+		}
+		for _, out := range outputs {
+			out.Close()
+		}
+		if len(results) > 1 {
+			fmt.Println()
+			PrintComparison(results)
+		}
+		if interrupted {
+			os.Exit(130)
+		}
+	}
 }
 
 type Scenario struct {
+	Name                     string
 	CreateTableSql           string
 	DropTableSql             string
 	AppendUri                string
 	AppendWorker             int
 	AppendRecordsPerRun      int
 	AppendWorkerRunPerSecond int
-	AppendRecordDataFunc     func(workerId int, now time.Time, nRun int, nRecord int, w io.Writer)
+	AppendRecordDataFunc     func(rng *rand.Rand, workerId int, now time.Time, nRun int, nRecord int) []any
+	AppendActiveFunc         func(rng *rand.Rand, workerId int, now time.Time, nRun int) bool // nil if the source is always active
 	SelectWorker             int
 	SelectWorkerRunPerSecond int
-	SelectSqlFunc            func(workerId int, now time.Time) string
+	SelectSqlFunc            func(rng *rand.Rand, workerId int, now time.Time) (string, string) // template name and SQL text
 	Timeout                  time.Duration
+	Stages                   StageProfile
+	VerifyTarget             *VerifyTarget // nil if the records can not be verified
+	verifyErr                error
+	AppendColumns            []string        // names of the columns of the records
+	AppendColumnTypes        []string        // types of the columns of the records
+	Telemetry                []TelemetryView // server views defined by the scenario
+}
+
+// RunOptions are the command line options of a scenario run.
+type RunOptions struct {
+	NeoHttpAddr        string   // the first of the endpoints, verify, telemetry and the lag probe read from it
+	Endpoints          []string // http endpoints of the requests
+	EndpointPolicy     string
+	NeoNativeDSN       string
+	Transport          string
+	Format             PayloadFormat
+	CleanStart         bool
+	CleanStop          bool
+	HttpTimeout        time.Duration
+	SlowQueryThreshold time.Duration
+	ErrorPolicy        *ErrorPolicy
+	OpenLoop           bool
+	MaxInflight        int
+	Outputs            []ResultWriter
+	Metrics            *Metrics
+	Verify             bool
+	VerifyWorkers      int
+	VerifyEvery        int
+	VerifyTimeout      time.Duration
+	Interrupt          <-chan os.Signal // stops the run gracefully
+	DrainTimeout       time.Duration    // in-flight requests are canceled after it when the run stops
+	Telemetry          []TelemetryView  // server views sampled at the end of every cycle
+	LagInterval        time.Duration    // interval of the markers of the lag probe, 0 disables it
+	LagTimeout         time.Duration
+	LagRollup          string // rollup unit of the lag probe, empty if no rollup
+	Seed               int64  // the RNGs of the workers are derived from it
 }
 
-func (s Scenario) Run(neoHttpAddr string, cleanStart bool, cleanStop bool, httpTimeout time.Duration, slowQueryThreshold time.Duration) {
-	if cleanStart {
-		s.DropTable(neoHttpAddr)
+// ErrInterrupted is returned by Run when it is stopped by a signal.
+var ErrInterrupted = errors.New("interrupted")
+
+// appendSplit is the number of append requests of a worker run,
+// each request carries AppendRecordsPerRun/appendSplit records.
+const appendSplit = 10
+
+// Run runs the scenario until the timeout or until the error policy stops it,
+// in that case the error is returned with the summary of the run.
+func (s Scenario) Run(opts RunOptions) (*Result, error) {
+	if opts.Verify {
+		if s.VerifyTarget == nil {
+			return nil, fmt.Errorf("scenario %s can not be verified: %w", s.Name, s.verifyErr)
+		}
+		if err := s.VerifyTarget.Verifiable(); err != nil {
+			return nil, fmt.Errorf("scenario %s can not be verified: %w", s.Name, err)
+		}
+	}
+	if opts.CleanStart {
+		s.DropTableOnce(opts)
+	}
+	// Create table, every endpoint may be a node of its own
+	for _, addr := range opts.httpEndpoints() {
+		s.CreateTable(addr)
 	}
-	// Create table
-	s.CreateTable(neoHttpAddr)
 
 	wg := sync.WaitGroup{}
 	closeCh := make(chan struct{})
+	closeOnce := sync.Once{}
+	var abortErr error
+	stop := func(err error) {
+		closeOnce.Do(func() {
+			abortErr = err
+			close(closeCh)
+		})
+	}
+	// ctx cancels the in-flight requests at the end of the drain
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case sig := <-opts.Interrupt:
+			fmt.Printf("\nInterrupted by %v, draining in-flight requests up to %v\n", sig, opts.DrainTimeout)
+			stop(ErrInterrupted)
+		case <-doneCh:
+			return
+		}
+		select {
+		case sig := <-opts.Interrupt:
+			fmt.Printf("\nInterrupted by %v again, exit\n", sig)
+			os.Exit(1)
+		case <-doneCh:
+		}
+	}()
 
 	client := &http.Client{
 		Transport: &http.Transport{
 			MaxIdleConnsPerHost: 20,
 			MaxConnsPerHost:     (s.AppendWorker + s.SelectWorker) * 2,
 		},
-		Timeout: httpTimeout,
-	}
-	// Append Data
-	for i := 0; i < s.AppendWorker; i++ {
-		wg.Add(1)
-		go func(workerId int) {
-			defer wg.Done()
-			round := 0
-			ticker := time.NewTicker(time.Second / time.Duration(s.AppendWorkerRunPerSecond))
-			for {
-				select {
-				case <-closeCh:
-					return
-				case <-ticker.C:
-					split := 10
-					for part := 0; part < split; part++ {
-						dataBuf := &bytes.Buffer{}
-						for n := 0; n < s.AppendRecordsPerRun/split; n++ {
-							s.AppendRecordDataFunc(workerId, time.Now(), round, part*split+n, dataBuf)
-						}
-						round++
-						req, err := http.NewRequest("POST", neoHttpAddr+s.AppendUri, bytes.NewBuffer(dataBuf.Bytes()))
-						if err != nil {
-							fmt.Println("Failed to create request:", err)
-							os.Exit(1)
-						}
-						req.Header.Set("Content-Type", "text/csv")
-						req.Header.Set("Content-Length", fmt.Sprintf("%d", dataBuf.Len()))
-						rsp, err := client.Do(req)
-						if err != nil {
-							fmt.Println("Failed to append data:", err)
-							os.Exit(1)
-						}
-						if rsp.StatusCode != http.StatusOK {
-							dumpResponse(rsp, "Failed to append data")
-							continue
-						}
-						content, err := io.ReadAll(rsp.Body)
-						if err != nil {
-							fmt.Println("Failed to read response body:", err)
-							os.Exit(1)
-						}
-						rsp.Body.Close()
-
-						jsonStr := string(content)
-						success := gjson.Get(jsonStr, "success").Bool()
-						reason := gjson.Get(jsonStr, "reason").String()
-						if !success {
-							fmt.Println("Failed to select data:", reason)
-							os.Exit(1)
-						}
-					}
-				}
-			}
-		}(i)
+		Timeout: opts.HttpTimeout,
 	}
+	policy := opts.ErrorPolicy
+	metrics := opts.Metrics
+	metrics.SetRun(opts.Transport, opts.Format.String())
+
+	// a transport per http endpoint, native has the only one of -neo-native,
+	// endpoints label the statistics of the requests
+	transports, endpoints := []Transport{}, []string{}
+	if opts.Transport == TransportNative {
+		transport, err := s.newTransport(opts, client)
+		if err != nil {
+			return nil, fmt.Errorf("transport %s: %w", opts.Transport, err)
+		}
+		transports, endpoints = append(transports, transport), append(endpoints, "")
+	} else {
+		for _, addr := range opts.httpEndpoints() {
+			endpointOpts := opts
+			endpointOpts.NeoHttpAddr = addr
+			transport, _ := s.newTransport(endpointOpts, client)
+			transports, endpoints = append(transports, transport), append(endpoints, addr)
+		}
+	}
+	picker := NewEndpointPicker(opts.EndpointPolicy, len(transports))
 
 	var stat = NewStat()
+	stat.Scenario = s.Name
+	stat.Transport = opts.Transport
+	if opts.Transport == TransportHttp {
+		stat.Format = opts.Format.String()
+	}
+	stat.Seed = opts.Seed
+	stat.AppendWorkers = s.AppendWorker
+	stat.SelectWorkers = s.SelectWorker
+	stat.Outputs = opts.Outputs
+	if len(opts.Telemetry) > 0 {
+		stat.Telemetry = NewTelemetrySampler(s, client, opts.NeoHttpAddr, opts.Telemetry)
+	}
 	stat.Start()
 
-	// Select Data
-	for i := 0; i < s.SelectWorker; i++ {
-		wg.Add(1)
-		go func(workerId int) {
-			defer wg.Done()
-			ticker := time.NewTicker(time.Second / time.Duration(s.SelectWorkerRunPerSecond))
-			for {
-				select {
-				case <-closeCh:
-					return
-				case ts := <-ticker.C:
-					sqlText := s.SelectSqlFunc(workerId, ts)
-					req, err := http.NewRequest("GET", neoHttpAddr+"/db/query?q="+url.QueryEscape(sqlText), nil)
-					if err != nil {
-						fmt.Println("Failed to create request:", err)
-						os.Exit(1)
-					}
-					reqTime := time.Now()
-					rsp, err := client.Do(req)
-					if err != nil {
-						fmt.Println("Failed to select data:", err)
-						os.Exit(1)
-					}
-					if rsp.StatusCode != http.StatusOK {
-						stat.AddSelectError()
-						dumpResponse(rsp, "Failed to select data")
-						continue
-					}
-					reqElapse := time.Since(reqTime)
+	var verifier *Verifier
+	if opts.Verify {
+		verifier = NewVerifier(s.VerifyTarget, opts.VerifyWorkers, opts.VerifyEvery, opts.VerifyTimeout)
+		verifier.Start(s, client, stat)
+	}
+	var lagProbe *LagProbe
+	if opts.LagInterval > 0 {
+		lagProbe = NewLagProbe(s.VerifyTarget, opts.LagInterval, opts.LagTimeout, opts.LagRollup)
+		lagProbe.Start(s, client, opts.NeoHttpAddr, stat)
+	}
 
-					content, err := io.ReadAll(rsp.Body)
-					if err != nil {
-						fmt.Println("Failed to read response body:", err)
-						os.Exit(1)
-					}
-					rsp.Body.Close()
+	// appendJob sends the part-th request of the round of the worker.
+	// intended is the time the request should have been sent.
+	appendJob := func(workerId int, round int, part int, intended time.Time) {
+		rng := workerRand(opts.Seed, seedAppend, workerId, round)
+		if s.AppendActiveFunc != nil && !s.AppendActiveFunc(rng, workerId, intended, round) {
+			// the source is off, e.g. between the bursts
+			return
+		}
+		records := make([][]any, s.AppendRecordsPerRun/appendSplit)
+		for n := range records {
+			records[n] = s.AppendRecordDataFunc(rng, workerId, time.Now(), round, part*appendSplit+n)
+		}
+		batch, err := opts.Format.Encode(s.AppendColumns, records)
+		if err != nil {
+			abort, err := policy.Fail(&RequestError{Category: "encode", Err: err})
+			stat.AddError("append", err)
+			stat.AddFailed("append")
+			policy.Report("append", err)
+			if abort {
+				stop(err)
+			}
+			return
+		}
+		idx := picker.Pick(rng, workerId)
+		abort, err := policy.Do(closeCh, func() error {
+			metrics.Begin("append")
+			defer metrics.End("append")
+			err := transports[idx].Append(ctx, batch)
+			metrics.ObserveAppend(int64(len(records)), int64(len(batch.Body)), time.Since(intended), err)
+			if err != nil {
+				stat.AddAppendError(endpoints[idx], err)
+				return err
+			}
+			stat.AddAppend(AppendSample{
+				Endpoint: endpoints[idx],
+				Records:  int64(len(records)),
+				Bytes:    int64(len(batch.Body)),
+				RawBytes: int64(batch.RawSize),
+				Encode:   batch.Encode,
+				Elapse:   time.Since(intended),
+			})
+			return nil
+		})
+		if err == nil && verifier != nil {
+			// read back from the node that took the records
+			verifyAddr := opts.NeoHttpAddr
+			if endpoints[idx] != "" {
+				verifyAddr = endpoints[idx]
+			}
+			verifier.Add(verifyAddr, records, time.Now(), stat)
+		}
+		if err != nil {
+			stat.AddFailed("append")
+			policy.Report("append", err)
+		}
+		if abort {
+			stop(err)
+		}
+	}
+
+	// selectJob executes the n-th query of the worker.
+	// intended is the time the query should have been sent.
+	selectJob := func(workerId int, n int, intended time.Time) {
+		rng := workerRand(opts.Seed, seedSelect, workerId, n)
+		template, sqlText := s.SelectSqlFunc(rng, workerId, intended)
+		idx := picker.Pick(rng, workerId)
+		abort, err := policy.Do(closeCh, func() error {
+			metrics.Begin("select")
+			defer metrics.End("select")
+			rows, elapse, err := transports[idx].Select(ctx, sqlText)
+			metrics.ObserveSelect(rows, elapse, time.Since(intended), err)
+			if err != nil {
+				stat.AddSelectError(endpoints[idx], template, err)
+				return err
+			}
+			stat.AddSelect(SelectSample{Endpoint: endpoints[idx], Template: template, Rows: rows, Query: elapse, Elapse: time.Since(intended)})
+			if opts.SlowQueryThreshold > 0 && elapse > opts.SlowQueryThreshold {
+				fmt.Println("Slow query elapse:", elapse, "\n", sqlText)
+			}
+			return nil
+		})
+		if err != nil {
+			stat.AddFailed("select")
+			policy.Report("select", err)
+		}
+		if abort {
+			stop(err)
+		}
+	}
 
-					jsonStr := string(content)
-					success := gjson.Get(jsonStr, "success").Bool()
-					if !success {
-						reason := gjson.Get(jsonStr, "reason").String()
-						fmt.Println("Failed to select data:", reason)
-						os.Exit(1)
+	if opts.OpenLoop || len(s.Stages) > 0 {
+		appendRate := ConstantRate(float64(s.AppendWorker * s.AppendWorkerRunPerSecond * appendSplit))
+		selectRate := ConstantRate(float64(s.SelectWorker * s.SelectWorkerRunPerSecond))
+		if len(s.Stages) > 0 {
+			appendRate, selectRate = s.Stages.AppendRate, s.Stages.SelectRate
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, st := range s.Stages {
+					stat.SetStage(st.Name)
+					metrics.SetStage(st.Name)
+					select {
+					case <-closeCh:
+						return
+					case <-time.After(st.Duration):
 					}
-					rows := gjson.Get(jsonStr, "data.rows").Array()
-					elapseStr := gjson.Get(jsonStr, "elapse").String()
-					elapse, err := time.ParseDuration(elapseStr)
-					if err != nil {
-						fmt.Println("Failed to parse elapse:", err)
-						os.Exit(1)
+				}
+			}()
+		}
+		appendWorker := max(s.AppendWorker, 1)
+		selectWorker := max(s.SelectWorker, 1)
+		// Append Data
+		if s.AppendRecordDataFunc != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				RunOpenLoop(closeCh, appendRate, opts.MaxInflight, func(seq int64, intended time.Time) {
+					stat.AddQueueDelay("append", time.Since(intended))
+					workerId := int(seq % int64(appendWorker))
+					nth := int(seq / int64(appendWorker))
+					appendJob(workerId, nth, nth%appendSplit, intended)
+				}, func() {
+					stat.AddMissed("append")
+				})
+			}()
+		}
+		// Select Data
+		if s.SelectSqlFunc != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				RunOpenLoop(closeCh, selectRate, opts.MaxInflight, func(seq int64, intended time.Time) {
+					stat.AddQueueDelay("select", time.Since(intended))
+					selectJob(int(seq%int64(selectWorker)), int(seq/int64(selectWorker)), intended)
+				}, func() {
+					stat.AddMissed("select")
+				})
+			}()
+		}
+	} else {
+		// Append Data
+		for i := 0; i < s.AppendWorker; i++ {
+			wg.Add(1)
+			go func(workerId int) {
+				defer wg.Done()
+				round := 0
+				ticker := time.NewTicker(time.Second / time.Duration(s.AppendWorkerRunPerSecond))
+				defer ticker.Stop()
+				for {
+					select {
+					case <-closeCh:
+						return
+					case <-ticker.C:
+						if stopped(closeCh) {
+							return
+						}
+						for part := 0; part < appendSplit; part++ {
+							appendJob(workerId, round, part, time.Now())
+							round++
+						}
 					}
-					stat.AddSelectRowsCount(int64(len(rows)))
-					stat.AddSelectTime(elapse, reqElapse)
-					if slowQueryThreshold > 0 && elapse > slowQueryThreshold {
-						fmt.Println("Slow query elapse:", elapse, "\n", sqlText)
+				}
+			}(i)
+		}
+		// Select Data
+		for i := 0; i < s.SelectWorker; i++ {
+			wg.Add(1)
+			go func(workerId int) {
+				defer wg.Done()
+				ticker := time.NewTicker(time.Second / time.Duration(s.SelectWorkerRunPerSecond))
+				defer ticker.Stop()
+				for n := 0; ; n++ {
+					select {
+					case <-closeCh:
+						return
+					case <-ticker.C:
+						if stopped(closeCh) {
+							return
+						}
+						selectJob(workerId, n, time.Now())
 					}
 				}
-			}
-		}(i)
+			}(i)
+		}
 	}
 
 	if s.Timeout > 0 {
 		go func() {
 			timeout := time.After(s.Timeout)
 			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
 			for {
 				select {
 				case <-ticker.C:
 					stat.PrintAndReset()
 				case <-timeout:
-					close(closeCh)
+					stop(nil)
+					return
+				case <-closeCh:
 					return
 				}
 			}
 		}()
 	}
-	// Wait for all workers
+	// Wait for all workers, in-flight requests are canceled after the drain timeout
+	<-closeCh
+	drainTimer := time.AfterFunc(opts.DrainTimeout, func() {
+		fmt.Println("Drain timeout, canceling in-flight requests")
+		cancel()
+	})
 	wg.Wait()
+	drainTimer.Stop()
+	for _, transport := range transports {
+		transport.Close()
+	}
 
+	if verifier != nil {
+		// verify the ranges of the last requests
+		verifier.Stop(opts.DrainTimeout)
+	}
+	if lagProbe != nil {
+		lagProbe.Stop(opts.DrainTimeout)
+	}
 	stat.Stop()
 
-	if cleanStop {
-		s.DropTable(neoHttpAddr)
+	if opts.CleanStop {
+		s.DropTableOnce(opts)
+	}
+	return stat.Summary(), abortErr
+}
+
+// stopped reports whether the run is stopped, a tick of a worker may be
+// ready together with closeCh and no request is sent after the stop.
+func stopped(closeCh <-chan struct{}) bool {
+	select {
+	case <-closeCh:
+		return true
+	default:
+		return false
 	}
 }
 
+// appendData sends the records encoded in the format to the append uri.
+func (s Scenario) appendData(ctx context.Context, client *http.Client, neoHttpAddr string, format PayloadFormat, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", neoHttpAddr+s.AppendUri, bytes.NewReader(data))
+	if err != nil {
+		return &RequestError{Category: "transport", Err: err}
+	}
+	req.Header.Set("Content-Type", format.ContentType())
+	if format.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	rsp, err := client.Do(req)
+	if err != nil {
+		return transportError(err)
+	}
+	defer rsp.Body.Close()
+	content, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return &RequestError{Category: "read", Err: err}
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return statusError(rsp, content)
+	}
+	jsonStr := string(content)
+	if !gjson.Get(jsonStr, "success").Bool() {
+		return reasonError(gjson.Get(jsonStr, "reason").String())
+	}
+	return nil
+}
+
+// selectData executes the query and returns the number of rows
+// and the elapsed time that is said in the response JSON.
+func (s Scenario) selectData(ctx context.Context, client *http.Client, neoHttpAddr string, sqlText string) (int64, time.Duration, error) {
+	rsp, err := s.queryJSON(ctx, client, neoHttpAddr, sqlText, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows := rsp.Get("data.rows").Array()
+	elapse, err := time.ParseDuration(rsp.Get("elapse").String())
+	if err != nil {
+		return 0, 0, &RequestError{Category: "parse", Err: err}
+	}
+	return int64(len(rows)), elapse, nil
+}
+
+// queryJSON executes the query with the additional parameters
+// and returns the response JSON of a successful query.
+func (s Scenario) queryJSON(ctx context.Context, client *http.Client, neoHttpAddr string, sqlText string, params url.Values) (gjson.Result, error) {
+	q := url.Values{"q": {sqlText}}
+	for k, v := range params {
+		q[k] = v
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", neoHttpAddr+"/db/query?"+q.Encode(), nil)
+	if err != nil {
+		return gjson.Result{}, &RequestError{Category: "transport", Err: err}
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return gjson.Result{}, transportError(err)
+	}
+	defer rsp.Body.Close()
+	content, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return gjson.Result{}, &RequestError{Category: "read", Err: err}
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return gjson.Result{}, statusError(rsp, content)
+	}
+	ret := gjson.ParseBytes(content)
+	if !ret.Get("success").Bool() {
+		return gjson.Result{}, reasonError(ret.Get("reason").String())
+	}
+	return ret, nil
+}
+
 func (s Scenario) CreateTable(neoHttpAddr string) {
 	if s.CreateTableSql != "" {
 		rsp, err := http.Get(neoHttpAddr + "/db/query?q=" + url.QueryEscape(s.CreateTableSql))
@@ -405,6 +910,12 @@ func (s Scenario) CreateTable(neoHttpAddr string) {
 	}
 }
 
+// DropTableOnce drops the table through the first endpoint,
+// the endpoints of a cluster share the table, it is not dropped again through the others.
+func (s Scenario) DropTableOnce(opts RunOptions) {
+	s.DropTable(opts.httpEndpoints()[0])
+}
+
 func (s Scenario) DropTable(neoHttpAddr string) {
 	if s.DropTableSql != "" {
 		rsp, err := http.Get(neoHttpAddr + "/db/query?q=" + url.QueryEscape(s.DropTableSql))
@@ -430,148 +941,3 @@ func dumpResponse(rsp *http.Response, msg string) {
 	fmt.Println("Body:")
 	io.Copy(os.Stdout, rsp.Body)
 }
-
-type Stat struct {
-	createdTime         time.Time
-	selectCount         int64
-	selectRowsCount     int64
-	selectErrorCount    int64
-	selectQueryTotal    int64
-	selectMinElapse     int64
-	selectMaxElapse     int64
-	selectHttpTotal     int64
-	selectHttpMinElapse int64
-	selectHttpMaxElapse int64
-
-	selectCumulativeCount      int64
-	selectCumulativeRowsCount  int64
-	selectErrorCumulativeCount int64
-	selectMaxElapseWatermark   int64
-	selectMaxHttpWatermark     int64
-
-	wg               sync.WaitGroup
-	selectRowsCountC chan int64
-	selectTimeC      chan [2]time.Duration // [query, wait]
-	commandC         chan StatCommand
-}
-
-type StatCommand string
-
-func NewStat() *Stat {
-	return &Stat{
-		createdTime:      time.Now(),
-		selectRowsCountC: make(chan int64, 100),
-		selectTimeC:      make(chan [2]time.Duration, 100),
-		commandC:         make(chan StatCommand, 10),
-	}
-}
-
-func (stat *Stat) AddSelectError() {
-	atomic.AddInt64(&stat.selectErrorCount, 1)
-}
-
-func (stat *Stat) AddSelectTime(queryElapse time.Duration, waitElapse time.Duration) {
-	stat.selectTimeC <- [2]time.Duration{queryElapse, waitElapse}
-}
-
-func (stat *Stat) AddSelectRowsCount(count int64) {
-	stat.selectRowsCountC <- count
-}
-
-func (stat *Stat) Start() {
-	stat.wg.Add(1)
-	go func() {
-		defer stat.wg.Done()
-		for {
-			select {
-			case dur := <-stat.selectTimeC:
-				queryElapse := dur[0].Nanoseconds()
-				httpElapse := dur[1].Nanoseconds()
-				stat.selectCount++
-				stat.selectQueryTotal += queryElapse
-				stat.selectHttpTotal += httpElapse
-
-				if queryElapse > stat.selectMaxElapse {
-					stat.selectMaxElapse = queryElapse
-				}
-				if queryElapse < stat.selectMinElapse || stat.selectMinElapse == 0 {
-					stat.selectMinElapse = queryElapse
-				}
-				if httpElapse > stat.selectHttpMaxElapse {
-					stat.selectHttpMaxElapse = httpElapse
-				}
-				if httpElapse < stat.selectHttpMinElapse || stat.selectHttpMinElapse == 0 {
-					stat.selectHttpMinElapse = httpElapse
-				}
-			case count := <-stat.selectRowsCountC:
-				stat.selectRowsCount += count
-			case cmd := <-stat.commandC:
-				if cmd == "stop" {
-					return
-				}
-				stat.selectCumulativeCount += stat.selectCount
-				stat.selectCumulativeRowsCount += stat.selectRowsCount
-				stat.selectErrorCumulativeCount += stat.selectErrorCount
-				if stat.selectMaxElapseWatermark < stat.selectMaxElapse {
-					stat.selectMaxElapseWatermark = stat.selectMaxElapse
-				}
-				if stat.selectMaxHttpWatermark < stat.selectHttpMaxElapse {
-					stat.selectMaxHttpWatermark = stat.selectHttpMaxElapse
-				}
-				stat.print()
-
-				if cmd == "print-reset" {
-					stat.selectCount = 0
-					stat.selectRowsCount = 0
-					stat.selectErrorCount = 0
-					stat.selectQueryTotal = 0
-					stat.selectMinElapse = 0
-					stat.selectMaxElapse = 0
-					stat.selectHttpTotal = 0
-					stat.selectHttpMinElapse = 0
-					stat.selectHttpMaxElapse = 0
-				}
-			}
-		}
-	}()
-}
-
-func (stat *Stat) Stop() {
-	stat.commandC <- "stop"
-	stat.wg.Wait()
-	close(stat.selectTimeC)
-	close(stat.commandC)
-	stat.print()
-}
-
-func (stat *Stat) Print() {
-	stat.commandC <- "print"
-}
-
-func (stat *Stat) PrintAndReset() {
-	stat.commandC <- "print-reset"
-}
-
-var printer = message.NewPrinter(language.English)
-
-func (stat *Stat) print() {
-	if stat.selectCount > 0 {
-		printer.Printf("Elapsed: %v\n", time.Since(stat.createdTime))
-		printer.Printf("Cumulative select: %d error: %d rows: %d query-max: %v http-max: %v\n",
-			stat.selectCumulativeCount, stat.selectErrorCumulativeCount,
-			stat.selectCumulativeRowsCount,
-			time.Duration(stat.selectMaxElapseWatermark),
-			time.Duration(stat.selectMaxHttpWatermark))
-		printer.Printf("This cycle select: %d error: %d rows: %d\n",
-			stat.selectCount, stat.selectErrorCount, stat.selectRowsCount)
-		printer.Printf("         http-avg: %v http-min: %v http-max: %v\n",
-			time.Duration(stat.selectHttpTotal/stat.selectCount),
-			time.Duration(stat.selectHttpMinElapse),
-			time.Duration(stat.selectHttpMaxElapse))
-		printer.Printf("        query-avg: %v query-min: %v query-max: %v\n",
-			time.Duration(stat.selectQueryTotal/stat.selectCount),
-			time.Duration(stat.selectMinElapse),
-			time.Duration(stat.selectMaxElapse))
-		printer.Println()
-	}
-}
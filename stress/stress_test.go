@@ -0,0 +1,958 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpr(t *testing.T) {
+	env := NewEnv()
+	env.Set("worker", 3)
+	env.Set("nth", 950)
+	tests := []struct {
+		src  string
+		want int64
+	}{
+		{"1_000 + 2 * 3", 1006},
+		{"(1 + 2) * 3", 9},
+		{"worker * 500 + 7 % 5", 1502},
+		{"10s", int64(10 * time.Second)},
+		{"-1m", -int64(time.Minute)},
+		{"worker == 3 && nth >= 900", 1},
+		{"if(worker == 0, 1, 2)", 2},
+		{"!0 + max(4, 5)", 6},
+		{"undefined", 0},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.src)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		if got := e.Eval(env); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.src, got, tt.want)
+		}
+	}
+	for _, src := range []string{"1 +", "foo(1)", "rand(1, 2)", "(1", "1 2"} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	item, _ := ParseTemplate("'w-{worker}'", nil)
+	tmpl, err := ParseTemplate("in ({ws}) and time > {now-1s} and t < '{now|datetime}'", map[string]*List{
+		"ws": {Count: 3, Sep: ",", Item: item},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local)
+	env := NewEnv()
+	env.Set("worker", 7)
+	env.Set("now", now.UnixNano())
+	got := tmpl.Execute(env)
+	want := "in ('w-7','w-7','w-7') and time > " + strconv.FormatInt(now.Add(-time.Second).UnixNano(), 10) +
+		" and t < '2025-01-02 03:04:05'"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBundledScenarios(t *testing.T) {
+	names := BundledScenarioNames()
+	if len(names) == 0 {
+		t.Fatal("no bundled scenarios")
+	}
+	for _, name := range names {
+		s, err := LoadScenario(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		rec := s.AppendRecordDataFunc(nil, 0, time.Now(), 1, 950)
+		if len(rec) != len(s.AppendColumns) {
+			t.Errorf("%s: unexpected record %v", name, rec)
+		}
+		for _, format := range []PayloadFormat{{Name: PayloadCSV}, {Name: PayloadJSON}, {Name: PayloadNDJSON, Gzip: true}} {
+			if batch, err := format.Encode(s.AppendColumns, [][]any{rec}); err != nil || len(batch.Body) == 0 {
+				t.Errorf("%s: %s encode %v", name, format, err)
+			}
+		}
+		if name, sqlText := s.SelectSqlFunc(nil, 0, time.Now()); name == "" || strings.Contains(sqlText, "{") {
+			t.Errorf("%s: unexpanded placeholder in %q", name, sqlText)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if h.Count() != 10000 || h.Min() != time.Microsecond || h.Max() != 10*time.Millisecond {
+		t.Fatalf("count=%d min=%v max=%v", h.Count(), h.Min(), h.Max())
+	}
+	for _, p := range Percentiles {
+		want := float64(p / 100 * 10000 * float64(time.Microsecond))
+		got := float64(h.Percentile(p))
+		if got < want*0.98 || got > want*1.02 {
+			t.Errorf("p%v: got %v, want %v", p, time.Duration(got), time.Duration(want))
+		}
+	}
+	o := NewHistogram()
+	o.Record(time.Second)
+	h.Merge(o)
+	if h.Max() != time.Second || h.Percentile(100) != time.Second {
+		t.Errorf("merged max: %v p100: %v", h.Max(), h.Percentile(100))
+	}
+	h.Reset()
+	if h.Count() != 0 || h.Percentile(99) != 0 {
+		t.Errorf("reset count=%d", h.Count())
+	}
+}
+
+func TestStageProfile(t *testing.T) {
+	stages, err := ParseStages("warm=10s:ramp:100:10,20s:step:100:10,spike=5s:spike:500:50,down=10s:ramp:0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := StageProfile(stages)
+	if sp.Duration() != 45*time.Second || sp[1].Name != "stage-1" {
+		t.Fatalf("duration=%v name=%q", sp.Duration(), sp[1].Name)
+	}
+	tests := []struct {
+		elapsed time.Duration
+		idx     int
+		append  float64
+		select_ float64
+	}{
+		{0, 0, 0, 0},
+		{5 * time.Second, 0, 50, 5},
+		{10 * time.Second, 1, 100, 10},
+		{31 * time.Second, 2, 500, 50},
+		{33 * time.Second, 2, 100, 10},
+		{40 * time.Second, 3, 50, 5},
+		{45 * time.Second, 4, 0, 0},
+	}
+	for _, tt := range tests {
+		idx, a, s := sp.At(tt.elapsed)
+		if idx != tt.idx || a != tt.append || s != tt.select_ {
+			t.Errorf("At(%v) = %d, %v, %v", tt.elapsed, idx, a, s)
+		}
+	}
+	// hold is the step shape
+	if stages, err := ParseStages("10s:hold:1:1"); err != nil || stages[0].Shape != StageStep {
+		t.Errorf("hold: %v %v", stages, err)
+	}
+	for _, str := range []string{"10s:ramp:1", "10s:wave:1:1", "-1s:step:1:1", "10s:step:x:1"} {
+		if _, err := ParseStages(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("default", 2, 3)
+	m.SetRun(TransportHttp, "csv")
+	m.ObserveAppend(10, 100, 3*time.Millisecond, nil)
+	m.ObserveAppend(10, 100, 2*time.Second, &RequestError{Category: "http 500", Err: errors.New("boom")})
+	m.ObserveSelect(5, time.Millisecond, 20*time.Millisecond, nil)
+	// the next run of the list has its own counters
+	m.SetRun(TransportHttp, "json+gzip")
+	m.ObserveAppend(20, 50, time.Millisecond, nil)
+	m.Begin("select")
+
+	buf := &bytes.Buffer{}
+	m.Write(buf)
+	out := buf.String()
+	for _, line := range []string{
+		`stress_info{scenario="default",stage="",transport="http",format="json+gzip"} 1`,
+		`stress_workers{op="select"} 3`,
+		`stress_active_workers{op="select",transport="http",format="csv"} 0`,
+		`stress_active_workers{op="select",transport="http",format="json+gzip"} 1`,
+		`stress_requests_total{op="append",transport="http",format="csv"} 2`,
+		`stress_requests_total{op="append",transport="http",format="json+gzip"} 1`,
+		`stress_rows_total{op="append",transport="http",format="csv"} 10`,
+		`stress_rows_total{op="select",transport="http",format="csv"} 5`,
+		`stress_rows_total{op="append",transport="http",format="json+gzip"} 20`,
+		`stress_append_bytes_total{transport="http",format="csv"} 100`,
+		`stress_append_bytes_total{transport="http",format="json+gzip"} 50`,
+		`stress_errors_total{op="append",category="http 500",transport="http",format="csv"} 1`,
+		`stress_latency_seconds_bucket{op="append",transport="http",format="csv",le="0.001"} 0`,
+		`stress_latency_seconds_bucket{op="append",transport="http",format="csv",le="0.005"} 1`,
+		`stress_latency_seconds_bucket{op="append",transport="http",format="csv",le="2.5"} 2`,
+		`stress_latency_seconds_bucket{op="append",transport="http",format="csv",le="+Inf"} 2`,
+		`stress_latency_seconds_count{op="append",transport="http",format="json+gzip"} 1`,
+		`stress_latency_seconds_count{op="select",transport="http",format="csv"} 1`,
+		`stress_select_query_seconds_count{transport="http",format="csv"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+
+	// a run given again continues its counters
+	m.SetRun(TransportHttp, "csv")
+	m.ObserveAppend(10, 100, time.Millisecond, nil)
+	buf.Reset()
+	m.Write(buf)
+	if line := `stress_requests_total{op="append",transport="http",format="csv"} 3`; !strings.Contains(buf.String(), line+"\n") {
+		t.Errorf("missing %q in\n%s", line, buf)
+	}
+}
+
+func TestVerifyRange(t *testing.T) {
+	sf := ScenarioFile{Append: AppendSpec{
+		Uri: "/db/write/test_table?method=append",
+		Columns: []ColumnSpec{
+			{Name: "name", Type: "tag", Template: "tag_{nth}"},
+			{Name: "time", Type: "timestamp"},
+			{Name: "value", Type: "random"},
+		},
+	}}
+	vt, err := sf.Append.verifyTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vr, err := vt.newVerifyRange([][]any{
+		{"a", int64(100), 1.5}, {"b", int64(200), 2.5}, {"a", int64(300), 3.5}, {"a", int64(300), 3.5},
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlText := vt.sqlText(vr)
+	if sqlText != "SELECT name, time, value FROM test_table WHERE name IN ('a','b') AND time BETWEEN 100 AND 300" {
+		t.Fatalf("sql: %s", sqlText)
+	}
+	tests := []struct {
+		name string
+		got  map[verifyKey][]float64
+		want VerifySample
+	}{
+		{"exact", map[verifyKey][]float64{{"a", 100}: {1.5}, {"b", 200}: {2.5}, {"a", 300}: {3.5, 3.5}},
+			VerifySample{Rows: 4}},
+		{"missing", map[verifyKey][]float64{{"a", 100}: {1.5}, {"a", 300}: {3.5}},
+			VerifySample{Rows: 4, Missing: 2}},
+		{"duplicate", map[verifyKey][]float64{{"a", 100}: {1.5, 1.5}, {"b", 200}: {2.5}, {"a", 300}: {3.5, 3.5}},
+			VerifySample{Rows: 4, Duplicate: 1}},
+		{"corrupt", map[verifyKey][]float64{{"a", 100}: {1.5}, {"b", 200}: {9}, {"a", 300}: {3.5, 3.5}},
+			VerifySample{Rows: 4, Corrupt: 1}},
+		{"other rows", map[verifyKey][]float64{{"a", 100}: {1.5}, {"b", 200}: {2.5}, {"a", 300}: {3.5, 3.5}, {"c", 150}: {1}, {"a", 250}: {7}},
+			VerifySample{Rows: 4}},
+	}
+	for _, tt := range tests {
+		if got := vr.compare(tt.got); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// two requests of the same tag in overlapping time ranges read back each other's rows
+	vr1, _ := vt.newVerifyRange([][]any{{"a", int64(100), 1.0}, {"a", int64(300), 3.0}}, time.Now())
+	vr2, _ := vt.newVerifyRange([][]any{{"a", int64(200), 2.0}, {"a", int64(400), 4.0}}, time.Now())
+	table := map[verifyKey][]float64{{"a", 100}: {1}, {"a", 200}: {2}, {"a", 300}: {3}, {"a", 400}: {4}}
+	for i, vr := range []*verifyRange{vr1, vr2} {
+		if got := vr.compare(table); got != (VerifySample{Rows: 2}) {
+			t.Errorf("overlapping request %d: %+v", i+1, got)
+		}
+	}
+	delete(table, verifyKey{"a", 200})
+	if got := vr2.compare(table); got != (VerifySample{Rows: 2, Missing: 1}) {
+		t.Errorf("overlapping request missing: %+v", got)
+	}
+	if err := vt.Verifiable(); err != nil {
+		t.Errorf("verifiable: %v", err)
+	}
+	sf.Append.Columns[1].Offset = "late(2, 1m) + dup(5, 1s)"
+	vt, err = sf.Append.verifyTarget()
+	if err != nil || vt.Verifiable() == nil {
+		t.Fatalf("dup offset: expected not verifiable, %v", err)
+	}
+	if _, err := (Scenario{Name: "dup", VerifyTarget: vt}).Run(RunOptions{Verify: true}); err == nil {
+		t.Error("dup offset: run expected error")
+	}
+	sf.Append.Uri = "/db/query"
+	if _, err := sf.Append.verifyTarget(); err == nil {
+		t.Error("expected error of the uri")
+	}
+}
+
+func TestPayloadFormat(t *testing.T) {
+	columns := []string{"name", "time", "value"}
+	records := [][]any{{"a", int64(100), 1.5}, {"b", int64(200), 0.25}}
+	tests := []struct {
+		format string
+		body   string
+	}{
+		{"csv", "a,100,1.500000\nb,200,0.250000\n"},
+		{"json", `{"data":{"columns":["name","time","value"],"rows":[["a",100,1.5],["b",200,0.25]]}}` + "\n"},
+		{"ndjson", `{"name":"a","time":100,"value":1.5}` + "\n" + `{"name":"b","time":200,"value":0.25}` + "\n"},
+	}
+	for _, tt := range tests {
+		formats, err := ParsePayloadFormats(tt.format + "," + tt.format + "+gzip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch, err := formats[0].Encode(columns, records)
+		if err != nil || string(batch.Body) != tt.body || batch.RawSize != len(tt.body) {
+			t.Errorf("%s: %q %v", tt.format, batch.Body, err)
+		}
+		batch, err = formats[1].Encode(columns, records)
+		if err != nil || batch.RawSize != len(tt.body) {
+			t.Fatalf("%s: %v", formats[1], err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(batch.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(zr); string(body) != tt.body {
+			t.Errorf("%s: %q", formats[1], body)
+		}
+	}
+	// a string of a comma, a quote or a newline is quoted
+	batch, err := PayloadFormat{Name: PayloadCSV}.Encode(columns, [][]any{{`a,"b"` + "\nc", int64(100), 1.5}})
+	if want := `"a,""b""` + "\nc\",100,1.500000\n"; err != nil || string(batch.Body) != want {
+		t.Errorf("csv quote: %q %v", batch.Body, err)
+	}
+	for _, str := range []string{"xml", "csv+zstd"} {
+		if _, err := ParsePayloadFormats(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+}
+
+func TestParseTelemetry(t *testing.T) {
+	scenarioViews := []TelemetryView{{Name: "rows", Sql: "SELECT count(*) FROM t"}, {Name: "session", Sql: "SELECT 1"}}
+	views, err := ParseTelemetry("mutex, rows,session", scenarioViews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 3 || views[0].Sql != TelemetryViews["mutex"] || views[1] != scenarioViews[0] || views[2] != scenarioViews[1] {
+		t.Errorf("views %v", views)
+	}
+	if views, err := ParseTelemetry("", nil); err != nil || len(views) != 0 {
+		t.Errorf("empty: %v %v", views, err)
+	}
+	if _, err := ParseTelemetry("mutex,locks", nil); err == nil {
+		t.Error("unknown view: expected error")
+	}
+}
+
+func TestCapacitySearch(t *testing.T) {
+	cs := CapacitySearch{Op: "select", Min: 10, Precision: 0.05, MaxProbes: 20}
+	probes := []CapacityProbe{}
+	for {
+		rate, ok := cs.Next(probes)
+		if !ok {
+			break
+		}
+		probes = append(probes, CapacityProbe{Rate: rate, Pass: rate <= 730})
+	}
+	pass, fail := cs.bounds(probes)
+	if pass > 730 || fail <= 730 || fail-pass > fail*cs.Precision {
+		t.Errorf("pass %v fail %v after %d probes", pass, fail, len(probes))
+	}
+	// the max bound stops the doubling
+	cs.Max = 50
+	probes = []CapacityProbe{{Rate: 10, Pass: true}, {Rate: 20, Pass: true}, {Rate: 40, Pass: true}}
+	if rate, ok := cs.Next(probes); !ok || rate != 50 {
+		t.Errorf("next %v %v", rate, ok)
+	}
+	if _, ok := cs.Next(append(probes, CapacityProbe{Rate: 50, Pass: true})); ok {
+		t.Error("expected the end of the search at the max")
+	}
+	// the lowest rate fails
+	if _, ok := cs.Next([]CapacityProbe{{Rate: 10}}); ok {
+		t.Error("expected the end of the search")
+	}
+
+	slo := SLO{P99: 100 * time.Millisecond, ErrorRate: 0.01}
+	r := &Result{DurationSec: 10, Errors: map[string]int64{"select/timeout": 2, "append/http 500": 50}}
+	r.Select.Count, r.Select.Errors, r.Select.Http.P99 = 998, 2, 80
+	if p := slo.Check("select", 100, r); !p.Pass || p.Achieved != 100 || p.ErrorRate != 0.002 {
+		t.Errorf("pass: %+v", p)
+	}
+	r.Select.Http.P99 = 120
+	if p := slo.Check("select", 120, r); p.Pass || !strings.Contains(p.Reason, "p99") || !strings.Contains(p.Reason, "achieved") {
+		t.Errorf("fail: %+v", p)
+	}
+
+	// a retried request is counted once, every tenth request fails 3 times before it succeeds
+	// and the last one fails all its attempts
+	errBoom := &RequestError{Category: "http 500", Err: errors.New("boom")}
+	policy, _ := ParseErrorPolicy(OnErrorRetry, 3, 0, "")
+	stat := NewStat()
+	stat.Start()
+	for i := range 101 {
+		calls := 0
+		_, err := policy.Do(nil, func() error {
+			calls++
+			if (i%10 == 0 && calls <= 3) || i == 100 {
+				stat.AddSelectError("", "q", errBoom)
+				return errBoom
+			}
+			stat.AddSelect(SelectSample{Template: "q", Rows: 1, Elapse: time.Millisecond})
+			return nil
+		})
+		if err != nil {
+			stat.AddFailed("select")
+		}
+	}
+	stat.Stop()
+	r = stat.Summary()
+	r.DurationSec = 1
+	if r.Select.Count != 100 || r.Select.Errors != 1 || r.Select.Attempts != 134 || r.Errors["select/http 500"] != 34 {
+		t.Fatalf("retry: count=%d errors=%d attempts=%d %v", r.Select.Count, r.Select.Errors, r.Select.Attempts, r.Errors)
+	}
+	if p := slo.Check("select", 100, r); !p.Pass || p.Achieved != 101 || p.ErrorRate != 1.0/101 {
+		t.Errorf("retry: %+v", p)
+	}
+}
+
+func TestStatTemplates(t *testing.T) {
+	stat := NewStat()
+	stat.Start()
+	stat.AddSelect(SelectSample{Template: "latest", Rows: 1, Query: time.Millisecond, Elapse: 2 * time.Millisecond})
+	stat.AddSelect(SelectSample{Template: "latest", Rows: 1, Query: time.Millisecond, Elapse: 3 * time.Millisecond})
+	stat.AddSelect(SelectSample{Template: "range", Rows: 100, Query: 10 * time.Millisecond, Elapse: 12 * time.Millisecond})
+	stat.AddSelectError("", "range", &RequestError{Category: "timeout", Err: errors.New("timeout")})
+	stat.AddFailed("select")
+	stat.Stop()
+	r := stat.Summary()
+	if r.Select.Count != 3 || r.Select.Rows != 102 || r.Select.Errors != 1 || len(r.Select.Templates) != 2 {
+		t.Fatalf("select %+v", r.Select)
+	}
+	latest, rng := r.Select.Templates[0], r.Select.Templates[1]
+	if latest.Name != "latest" || latest.Count != 2 || latest.Rows != 2 || latest.Errors != 0 {
+		t.Errorf("latest %+v", latest)
+	}
+	if rng.Name != "range" || rng.Count != 1 || rng.Rows != 100 || rng.Errors != 1 || rng.Query.Max < 9.9 {
+		t.Errorf("range %+v", rng)
+	}
+}
+
+func TestLagRollup(t *testing.T) {
+	tests := []struct {
+		flag   string
+		create string
+		want   string
+	}{
+		{"auto", "CREATE TAG TABLE t (name varchar(40) primary key, time datetime basetime, value double summarized) with rollup(MIN)", "min"},
+		{"auto", "create tag table t (...) metadata (worker varchar(20)) WITH ROLLUP ( hour )", "hour"},
+		{"auto", "CREATE TAG TABLE t (name varchar(40) primary key, time datetime basetime, value double summarized)", ""},
+		{"none", "CREATE TAG TABLE t (...) with rollup(MIN)", ""},
+		{"sec", "CREATE TAG TABLE t (...)", "sec"},
+	}
+	for _, tt := range tests {
+		if got, err := ParseLagRollup(tt.flag, tt.create); err != nil || got != tt.want {
+			t.Errorf("%s %q: %q %v", tt.flag, tt.create, got, err)
+		}
+	}
+	if _, err := ParseLagRollup("day", ""); err == nil {
+		t.Error("day: expected error")
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	endpoints, err := ParseEndpoints("http://a:5654, http://b:5654/,https://c:5654")
+	if err != nil || len(endpoints) != 3 || endpoints[1] != "http://b:5654" {
+		t.Fatalf("endpoints %v %v", endpoints, err)
+	}
+	if _, err := ParseEndpoints("http://a:5654,b:5654"); err == nil {
+		t.Error("b:5654: expected error")
+	}
+	rr := NewEndpointPicker(EndpointRoundRobin, 3)
+	for i, want := range []int{0, 1, 2, 0, 1} {
+		if got := rr.Pick(nil, 7); got != want {
+			t.Errorf("round-robin %d: %d", i, got)
+		}
+	}
+	sticky := NewEndpointPicker(EndpointSticky, 3)
+	if sticky.Pick(nil, 4) != 1 || sticky.Pick(nil, 4) != 1 || sticky.Pick(nil, 5) != 2 {
+		t.Error("sticky")
+	}
+	random := NewEndpointPicker(EndpointRandom, 3)
+	for i := range 100 {
+		n := random.Pick(workerRand(42, seedSelect, 0, i), 0)
+		if n < 0 || n >= 3 {
+			t.Fatalf("random %d", n)
+		}
+		if again := random.Pick(workerRand(42, seedSelect, 0, i), 0); again != n {
+			t.Fatalf("random %d: %d, not reproducible by the seed: %d", i, n, again)
+		}
+	}
+
+	stat := NewStat()
+	stat.Start()
+	stat.AddAppend(AppendSample{Endpoint: "http://a:5654", Records: 10, Elapse: time.Millisecond})
+	stat.AddAppend(AppendSample{Endpoint: "http://b:5654", Records: 10, Elapse: 50 * time.Millisecond})
+	stat.AddAppendError("http://b:5654", &RequestError{Category: "timeout", Err: errors.New("timeout")})
+	stat.AddSelect(SelectSample{Endpoint: "http://b:5654", Template: "q", Rows: 3, Elapse: 60 * time.Millisecond})
+	stat.Stop()
+	r := stat.Summary()
+	if len(r.Endpoints) != 2 {
+		t.Fatalf("endpoints %+v", r.Endpoints)
+	}
+	a, b := r.Endpoints[0], r.Endpoints[1]
+	if a.Endpoint != "http://a:5654" || a.AppendCount != 1 || a.AppendErrors != 0 || a.SelectCount != 0 {
+		t.Errorf("a %+v", a)
+	}
+	if b.AppendCount != 1 || b.AppendErrors != 1 || b.SelectCount != 1 || b.SelectRows != 3 || b.AppendHttp.Max < 49 {
+		t.Errorf("b %+v", b)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	const n = 10000
+	r := rand.New(rand.NewSource(1))
+	zipfTop, hotCount := 0, 0
+	for range n {
+		if k := zipfKey(r, 1000, 120); k < 0 || k >= 1000 {
+			t.Fatalf("zipf %d", k)
+		} else if k < 10 {
+			zipfTop++
+		}
+		if k := hotKey(r, 1000, 10, 90); k < 0 || k >= 1000 {
+			t.Fatalf("hot %d", k)
+		} else if k < 100 {
+			hotCount++
+		}
+	}
+	// the top 1% of zipf(s=1.2) keys take more than half of the picks
+	if zipfTop < n/2 {
+		t.Errorf("zipf top keys %d of %d", zipfTop, n)
+	}
+	if hotCount < n*85/100 || hotCount > n*95/100 {
+		t.Errorf("hot keys %d of %d", hotCount, n)
+	}
+
+	late, disorder, dup := 0, 0, 0
+	for range n {
+		switch v := lateOffset(r, 10, int64(time.Minute)); v {
+		case -int64(time.Minute):
+			late++
+		case 0:
+		default:
+			t.Fatalf("late %d", v)
+		}
+		if v := disorderOffset(r, 100, int64(time.Second)); v > 0 || v <= -int64(time.Second) {
+			t.Fatalf("disorder %d", v)
+		} else if v != 0 {
+			disorder++
+		}
+		now := int64(12_345_678_901)
+		if v := dupOffset(r, 50, int64(time.Second), now); v != 0 {
+			if now+v != 12_000_000_000 {
+				t.Fatalf("dup %d", now+v)
+			}
+			dup++
+		}
+	}
+	if late < n*5/100 || late > n*15/100 {
+		t.Errorf("late %d of %d", late, n)
+	}
+	if disorder < n*99/100 {
+		t.Errorf("disorder %d of %d", disorder, n)
+	}
+	if dup < n*40/100 || dup > n*60/100 {
+		t.Errorf("dup %d of %d", dup, n)
+	}
+
+	e, err := ParseExpr("burst(10s, 20s, worker * 5s)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	for _, tt := range []struct {
+		now, worker, want int64
+	}{
+		{0, 0, 1}, {int64(9 * time.Second), 0, 1}, {int64(10 * time.Second), 0, 0},
+		{int64(29 * time.Second), 0, 0}, {int64(30 * time.Second), 0, 1}, {int64(5 * time.Second), 1, 0},
+	} {
+		env.Set("now", tt.now)
+		env.Set("worker", tt.worker)
+		if got := e.Eval(env); got != tt.want {
+			t.Errorf("burst now=%v worker=%d: %d", time.Duration(tt.now), tt.worker, got)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	s, err := LoadScenario("skew")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records := func(seed int64, workerId int, n int) string {
+		rng := workerRand(seed, seedAppend, workerId, n)
+		ret := ""
+		for nth := range 10 {
+			ret += fmt.Sprint(s.AppendRecordDataFunc(rng, workerId, now, n, nth))
+		}
+		return ret
+	}
+	queries := func(seed int64, workerId int, n int) string {
+		name, sqlText := s.SelectSqlFunc(workerRand(seed, seedSelect, workerId, n), workerId, now)
+		return name + sqlText
+	}
+	if records(42, 1, 7) != records(42, 1, 7) || queries(42, 1, 7) != queries(42, 1, 7) {
+		t.Error("the same seed, worker and request generated different data")
+	}
+	if records(42, 1, 7) == records(43, 1, 7) || records(42, 1, 7) == records(42, 0, 7) || records(42, 1, 7) == records(42, 1, 8) {
+		t.Error("a different seed, worker or request generated the same data")
+	}
+	diff := false
+	for n := range 10 {
+		diff = diff || queries(42, 0, n) != queries(43, 0, n)
+	}
+	if !diff {
+		t.Error("a different seed generated the same queries")
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	errBoom := &RequestError{Category: "http 500", Err: errors.New("boom")}
+	failing := func(n int, calls *int) func() error {
+		return func() error {
+			*calls++
+			if *calls <= n {
+				return errBoom
+			}
+			return nil
+		}
+	}
+
+	p, _ := ParseErrorPolicy(OnErrorRetry, 3, time.Millisecond, "")
+	calls := 0
+	if abort, err := p.Do(nil, failing(2, &calls)); abort || err != nil || calls != 3 {
+		t.Errorf("retry: abort=%v err=%v calls=%d", abort, err, calls)
+	}
+	calls = 0
+	start := time.Now()
+	if abort, err := p.Do(nil, failing(10, &calls)); abort || err != errBoom || calls != 4 {
+		t.Errorf("retry exhausted: abort=%v err=%v calls=%d", abort, err, calls)
+	}
+	// backoff 1ms, 2ms, 4ms
+	if elapsed := time.Since(start); elapsed < 7*time.Millisecond {
+		t.Errorf("retry backoff: %v", elapsed)
+	}
+	if p.requests != 2 || p.errors != 1 {
+		t.Errorf("retry counts: requests=%d errors=%d", p.requests, p.errors)
+	}
+	closeCh := make(chan struct{})
+	close(closeCh)
+	calls = 0
+	if abort, err := p.Do(closeCh, failing(10, &calls)); abort || err != errBoom || calls != 1 {
+		t.Errorf("retry closed: abort=%v err=%v calls=%d", abort, err, calls)
+	}
+	calls = 0
+	if abort, err := p.Fail(errBoom); abort || err != errBoom || calls != 0 {
+		t.Errorf("fail is not retried: abort=%v err=%v calls=%d", abort, err, calls)
+	}
+
+	p, _ = ParseErrorPolicy(OnErrorAbort, 0, 0, "")
+	if abort, err := p.Do(nil, func() error { return errBoom }); !abort || err != errBoom {
+		t.Errorf("abort: abort=%v err=%v", abort, err)
+	}
+
+	p, _ = ParseErrorPolicy(OnErrorCount, 0, 0, "10%")
+	for i := range 95 {
+		if abort, _ := p.Do(nil, func() error { return nil }); abort {
+			t.Fatalf("budget: aborted at success %d", i)
+		}
+	}
+	for i := 1; ; i++ {
+		abort, err := p.Do(nil, func() error { return errBoom })
+		if !abort {
+			continue
+		}
+		// 11 errors of 106 requests is the first rate above 10%
+		if i != 11 || !errors.Is(err, errBoom) || !strings.Contains(err.Error(), "exceeds budget") {
+			t.Errorf("budget: aborted at error %d: %v", i, err)
+		}
+		break
+	}
+	p, _ = ParseErrorPolicy(OnErrorCount, 0, 0, "10%")
+	for range errorBudgetMinRequests - 1 {
+		if abort, _ := p.Fail(errBoom); abort {
+			t.Fatal("budget: aborted before the minimum requests")
+		}
+	}
+	if abort, _ := p.Fail(errBoom); !abort {
+		t.Error("budget: encode errors not counted")
+	}
+	if _, err := ParseErrorPolicy("ignore", 0, 0, ""); err == nil {
+		t.Error("ignore: expected error")
+	}
+}
+
+func TestRunOpenLoop(t *testing.T) {
+	run := func(rate float64, d time.Duration, maxInflight int, job func()) (int64, int64, []time.Time) {
+		closeCh := make(chan struct{})
+		time.AfterFunc(d, func() { close(closeCh) })
+		var jobs, missed atomic.Int64
+		mu := sync.Mutex{}
+		intended := []time.Time{}
+		RunOpenLoop(closeCh, ConstantRate(rate), maxInflight, func(seq int64, at time.Time) {
+			jobs.Add(1)
+			mu.Lock()
+			intended = append(intended, at)
+			mu.Unlock()
+			job()
+		}, func() {
+			missed.Add(1)
+		})
+		return jobs.Load(), missed.Load(), intended
+	}
+
+	jobs, missed, intended := run(1000, 300*time.Millisecond, 4, func() {})
+	if jobs < 240 || jobs > 330 || missed != 0 {
+		t.Errorf("rate 1000/s for 300ms: jobs=%d missed=%d", jobs, missed)
+	}
+	sort.Slice(intended, func(i, j int) bool { return intended[i].Before(intended[j]) })
+	// the jobs queued at the close are not run, the gaps are checked before it
+	for i := 1; i < min(len(intended), 200); i++ {
+		if gap := intended[i].Sub(intended[i-1]); gap != time.Millisecond {
+			t.Fatalf("intended gap %d: %v", i, gap)
+		}
+	}
+
+	// below one request per idle interval the rate is accumulated
+	jobs, _, _ = run(20, 500*time.Millisecond, 1, func() {})
+	if jobs < 7 || jobs > 12 {
+		t.Errorf("rate 20/s for 500ms: jobs=%d", jobs)
+	}
+
+	// a blocked job fills the queue, the rest is missed, not run
+	release := make(chan struct{})
+	time.AfterFunc(400*time.Millisecond, func() { close(release) })
+	jobs, missed, _ = run(100000, 200*time.Millisecond, 1, func() { <-release })
+	if jobs != 1 || missed == 0 {
+		t.Errorf("full queue: jobs=%d missed=%d", jobs, missed)
+	}
+}
+
+func TestResultWriters(t *testing.T) {
+	dir := t.TempDir()
+	stat := NewStat()
+	stat.Scenario, stat.Transport, stat.Format, stat.Seed = "default", TransportHttp, "csv+gzip", 42
+	c := NewStatCounters()
+	c.selectCount, c.selectErrors, c.selectAttempts = 9, 1, 10
+	c.selectHttpHist.Record(3 * time.Millisecond)
+	c.appendCount, c.appendRecords = 5, 50
+	c.errors["select/timeout"] = 1
+	c.end = c.start.Add(time.Second)
+	results := []*Result{stat.result("cycle", "hold", c), stat.result("summary", "", c)}
+
+	jw, err := NewJSONResultWriter(filepath.Join(dir, "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := NewCSVResultWriter(filepath.Join(dir, "result.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if err := jw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+		if err := cw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jw.Close()
+	cw.Close()
+
+	b, _ := os.ReadFile(filepath.Join(dir, "result.json"))
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("json lines: %d", len(lines))
+	}
+	var got Result
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "cycle" || got.Stage != "hold" || got.Seed != 42 || got.Select.Count != 9 || got.Select.Attempts != 10 ||
+		got.Select.PerSec != 9 || got.Select.Http.Max != 3 || got.Append.Records != 50 || got.Errors["select/timeout"] != 1 {
+		t.Errorf("json: %+v", got)
+	}
+
+	f, _ := os.Open(filepath.Join(dir, "result.csv"))
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "time" {
+		t.Fatalf("csv: %d rows, header once", len(records))
+	}
+	col := map[string]int{}
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	for _, rec := range records[1:] {
+		if len(rec) != len(records[0]) {
+			t.Fatalf("csv: %d columns, header %d", len(rec), len(records[0]))
+		}
+	}
+	if rec := records[2]; rec[col["type"]] != "summary" || rec[col["format"]] != "csv+gzip" ||
+		rec[col["select_count"]] != "9" || rec[col["select_attempts"]] != "10" || rec[col["errors"]] != "1" {
+		t.Errorf("csv summary: %v", rec)
+	}
+}
+
+func TestRunInterrupt(t *testing.T) {
+	// every query takes delay, or until the request is canceled
+	var delay atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Duration(delay.Load())):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"reason":"success","elapse":"1ms","data":{"rows":[[1]]}}`)
+	}))
+	defer srv.Close()
+	s := Scenario{
+		Name:                     "interrupt",
+		SelectWorker:             2,
+		SelectWorkerRunPerSecond: 50,
+		SelectSqlFunc: func(rng *rand.Rand, workerId int, now time.Time) (string, string) {
+			return "q", "select 1"
+		},
+		Timeout: time.Minute,
+	}
+	run := func(drain time.Duration) (*Result, error, time.Duration) {
+		policy, _ := ParseErrorPolicy(OnErrorCount, 0, 0, "")
+		interrupt := make(chan os.Signal, 1)
+		time.AfterFunc(300*time.Millisecond, func() { interrupt <- os.Interrupt })
+		start := time.Now()
+		result, err := s.Run(RunOptions{
+			NeoHttpAddr:    srv.URL,
+			EndpointPolicy: EndpointRoundRobin,
+			Transport:      TransportHttp,
+			HttpTimeout:    time.Minute,
+			ErrorPolicy:    policy,
+			Interrupt:      interrupt,
+			DrainTimeout:   drain,
+		})
+		return result, err, time.Since(start)
+	}
+
+	// the in-flight queries complete within the drain timeout
+	delay.Store(int64(50 * time.Millisecond))
+	result, err, elapsed := run(5 * time.Second)
+	if !errors.Is(err, ErrInterrupted) || elapsed > 2*time.Second {
+		t.Fatalf("drained: err=%v elapsed=%v", err, elapsed)
+	}
+	if result.Select.Count == 0 || result.Errors["select/canceled"] != 0 {
+		t.Errorf("drained: count=%d errors=%v", result.Select.Count, result.Errors)
+	}
+
+	// the in-flight queries are canceled at the end of the drain timeout
+	delay.Store(int64(time.Minute))
+	result, err, elapsed = run(100 * time.Millisecond)
+	if !errors.Is(err, ErrInterrupted) || elapsed > 2*time.Second {
+		t.Fatalf("canceled: err=%v elapsed=%v", err, elapsed)
+	}
+	if result.Select.Count != 0 || result.Errors["select/canceled"] != 2 || result.Select.Attempts != 2 {
+		t.Errorf("canceled: count=%d attempts=%d errors=%v", result.Select.Count, result.Select.Attempts, result.Errors)
+	}
+}
+
+func TestVerifierStop(t *testing.T) {
+	// the rows are never visible, a query returns no rows or hangs until it is canceled
+	var hang atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"reason":"success","elapse":"1ms","data":{"rows":[]}}`)
+	}))
+	defer srv.Close()
+	vt := &VerifyTarget{Table: "t", TagColumn: "name", TimeColumn: "time", tagIdx: 0, timeIdx: 1, valueIdx: -1}
+	records := [][]any{{"a", int64(100)}, {"a", int64(200)}}
+
+	tests := []struct {
+		name    string
+		hang    bool
+		want    VerifyResult
+		wantErr int64
+	}{
+		// the ranges polled in the backoff are reported as they were last seen
+		{name: "missing", want: VerifyResult{Ranges: 2, Rows: 4, Missing: 4, Invisible: 2}},
+		// the hanging queries are canceled and not counted as errors
+		{name: "hang", hang: true, want: VerifyResult{Skipped: 4}},
+	}
+	for _, tt := range tests {
+		hang.Store(tt.hang)
+		stat := NewStat()
+		stat.Start()
+		v := NewVerifier(vt, 2, 1, time.Minute)
+		v.Start(Scenario{Name: "verify"}, srv.Client(), stat)
+		for range 2 {
+			v.Add(srv.URL, records, time.Now(), stat)
+		}
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		v.Stop(100 * time.Millisecond)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: stop took %v", tt.name, elapsed)
+		}
+		stat.Stop()
+		r := stat.Summary()
+		if r.Verify == nil {
+			t.Fatalf("%s: no verify result", tt.name)
+		}
+		got := *r.Verify
+		got.Delay = Latency{}
+		if got != tt.want || r.Errors["verify/canceled"] != 0 {
+			t.Errorf("%s: got %+v errors %v, want %+v", tt.name, got, r.Errors, tt.want)
+		}
+	}
+}
+
+func TestStatStop(t *testing.T) {
+	stat := NewStat()
+	stat.Start()
+	for range 10 {
+		stat.Print()
+	}
+	for i := range 500 {
+		stat.AddSelect(SelectSample{Template: "q", Rows: 1, Elapse: time.Millisecond})
+		if i%5 == 0 {
+			stat.AddSelectError("", "q", &RequestError{Category: "timeout", Err: errors.New("slow")})
+			stat.AddFailed("select")
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		stat.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop did not return")
+	}
+	if s := stat.Summary().Select; s.Count != 500 || s.Errors != 100 || s.Attempts != 600 {
+		t.Errorf("summary select: count=%d errors=%d attempts=%d", s.Count, s.Errors, s.Attempts)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// TelemetryViews are the bundled server views of the telemetry sampler.
+//
+//   - mutex: top conflicts of v$mutex, as c-apps/view.sql, it needs
+//     "alter system set TRACE_MUTEX_WAIT_STATUS=1" (c-apps/viewon.sql)
+//   - session: number of sessions
+//   - storage: storage of the tag tables
+//   - rollup: elapsed time and gap of the rollups
+var TelemetryViews = map[string]string{
+	"mutex": "select name, (conflict_count * 10000 / (try_count + 1) / 100) as rate, try_count, conflict_count, wait_msec, held_msec" +
+		" from v$mutex order by conflict_count desc limit 10",
+	"session": "select count(*) as sessions from v$session",
+	"storage": "select * from v$storage_tag_tables",
+	"rollup": "select C.rollup_name, C.last_elapsed_msec as elapsed_msec, B.table_end_rid - C.end_rid as gap" +
+		" from m$sys_tables A, v$storage_tag_tables B, v$rollup C" +
+		" where C.SOURCE_TABLE = A.NAME and B.ID = A.ID order by C.rollup_name",
+}
+
+// TelemetryView is a named query of a server view.
+type TelemetryView struct {
+	Name string
+	Sql  string
+}
+
+// ParseTelemetry resolves the comma separated names of the -telemetry flag
+// with the views of the scenario first, then with the bundled views.
+func ParseTelemetry(str string, scenarioViews []TelemetryView) ([]TelemetryView, error) {
+	ret := []TelemetryView{}
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, v := range scenarioViews {
+			if v.Name == name {
+				ret = append(ret, v)
+				found = true
+				break
+			}
+		}
+		if sqlText, ok := TelemetryViews[name]; ok && !found {
+			ret = append(ret, TelemetryView{Name: name, Sql: sqlText})
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown telemetry view %q", name)
+		}
+	}
+	return ret, nil
+}
+
+// TelemetrySample is the result of the views at the end of a statistics cycle,
+// Time is the same as the time of the cycle.
+type TelemetrySample struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"` // "telemetry"
+	Scenario  string            `json:"scenario"`
+	Transport string            `json:"transport"`
+	Format    string            `json:"format"`
+	Stage     string            `json:"stage,omitempty"`
+	Views     []TelemetryResult `json:"views"`
+}
+
+type TelemetryResult struct {
+	Name     string   `json:"name"`
+	ElapseMs float64  `json:"elapse_ms"`
+	Columns  []string `json:"columns,omitempty"`
+	Rows     [][]any  `json:"rows,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// telemetryQueryTimeout bounds a query of a view, a busy server
+// should not hold the sampler for long.
+const telemetryQueryTimeout = 5 * time.Second
+
+// TelemetrySampler queries the views over http.
+type TelemetrySampler struct {
+	s           Scenario
+	client      *http.Client
+	neoHttpAddr string
+	views       []TelemetryView
+}
+
+func NewTelemetrySampler(s Scenario, client *http.Client, neoHttpAddr string, views []TelemetryView) *TelemetrySampler {
+	return &TelemetrySampler{s: s, client: client, neoHttpAddr: neoHttpAddr, views: views}
+}
+
+// Sample queries the views, the errors of the queries are kept in the results.
+func (ts *TelemetrySampler) Sample(at time.Time) *TelemetrySample {
+	ret := &TelemetrySample{Time: at, Type: "telemetry"}
+	for _, v := range ts.views {
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryQueryTimeout)
+		start := time.Now()
+		rsp, err := ts.s.queryJSON(ctx, ts.client, ts.neoHttpAddr, v.Sql, nil)
+		cancel()
+		r := TelemetryResult{Name: v.Name, ElapseMs: float64(time.Since(start)) / float64(time.Millisecond)}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			for _, c := range rsp.Get("data.columns").Array() {
+				r.Columns = append(r.Columns, c.String())
+			}
+			for _, row := range rsp.Get("data.rows").Array() {
+				values := []any{}
+				for _, v := range row.Array() {
+					values = append(values, v.Value())
+				}
+				r.Rows = append(r.Rows, values)
+			}
+		}
+		ret.Views = append(ret.Views, r)
+	}
+	return ret
+}
+
+func (sample *TelemetrySample) print() {
+	for _, v := range sample.Views {
+		if v.Error != "" {
+			printer.Printf("Telemetry %s: %s\n", v.Name, v.Error)
+			continue
+		}
+		printer.Printf("Telemetry %s: %d rows in %.1fms\n", v.Name, len(v.Rows), v.ElapseMs)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "  %s\n", strings.Join(v.Columns, "\t"))
+		for _, row := range v.Rows {
+			cols := make([]string, len(row))
+			for i, c := range row {
+				cols[i] = fmt.Sprint(c)
+			}
+			fmt.Fprintf(tw, "  %s\n", strings.Join(cols, "\t"))
+		}
+		tw.Flush()
+	}
+	printer.Println()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Transport sends the append and select requests of a scenario.
+//
+//   - http: /db/write and /db/query of the http server
+//   - native: Appender and database/sql of neo-client over the machbase port
+type Transport interface {
+	// Append appends the records of the batch.
+	Append(ctx context.Context, batch *AppendBatch) error
+	// Select executes the query and returns the number of rows
+	// and the elapsed time of the query.
+	Select(ctx context.Context, sqlText string) (int64, time.Duration, error)
+	Close() error
+}
+
+const (
+	TransportHttp   = "http"
+	TransportNative = "native"
+)
+
+// ParseTransports parses the -transport flag, a comma separated list of transports.
+func ParseTransports(str string) ([]string, error) {
+	ret := []string{}
+	for _, t := range strings.Split(str, ",") {
+		switch t = strings.TrimSpace(t); t {
+		case TransportHttp, TransportNative:
+			ret = append(ret, t)
+		default:
+			return nil, fmt.Errorf("invalid -transport %q, use http or native", t)
+		}
+	}
+	return ret, nil
+}
+
+func (s Scenario) newTransport(opts RunOptions, client *http.Client) (Transport, error) {
+	if opts.Transport == TransportNative {
+		return newNativeTransport(s, opts.NeoNativeDSN)
+	}
+	return &httpTransport{s: s, client: client, neoHttpAddr: opts.NeoHttpAddr, format: opts.Format}, nil
+}
+
+type httpTransport struct {
+	s           Scenario
+	client      *http.Client
+	neoHttpAddr string
+	format      PayloadFormat
+}
+
+// Append sends the encoded body of the batch.
+func (t *httpTransport) Append(ctx context.Context, batch *AppendBatch) error {
+	return t.s.appendData(ctx, t.client, t.neoHttpAddr, t.format, batch.Body)
+}
+
+// Select returns the elapsed time that is said in the response JSON.
+func (t *httpTransport) Select(ctx context.Context, sqlText string) (int64, time.Duration, error) {
+	return t.s.selectData(ctx, t.client, t.neoHttpAddr, sqlText)
+}
+
+func (t *httpTransport) Close() error {
+	return nil
+}
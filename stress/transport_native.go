@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	client "github.com/machbase/neo-client/v2"
+)
+
+// nativeTransport appends with a pool of neo-client Appenders,
+// one per append worker, and selects with database/sql.
+//
+// Append returns when the records are handed to the Appender,
+// the Appender sends them to the server in the background,
+// so the append latency is the hand-off, not the response of the server.
+type nativeTransport struct {
+	columnTypes []string
+	db          *sql.DB
+	appenders   chan *client.Appender
+}
+
+func newNativeTransport(s Scenario, dsn string) (*nativeTransport, error) {
+	db, err := sql.Open("machbase", dsn)
+	if err != nil {
+		return nil, err
+	}
+	t := &nativeTransport{
+		columnTypes: s.AppendColumnTypes,
+		db:          db,
+		appenders:   make(chan *client.Appender, s.AppendWorker),
+	}
+	if s.AppendRecordDataFunc != nil && s.AppendWorker > 0 {
+		table, err := appendTable(s.AppendUri)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		for i := 0; i < s.AppendWorker; i++ {
+			appender := &client.Appender{}
+			if err := appender.Connect(context.Background(), dsn, table); err != nil {
+				t.Close()
+				return nil, fmt.Errorf("appender: %w", err)
+			}
+			t.appenders <- appender
+		}
+	}
+	return t, nil
+}
+
+// Append appends the records of the batch, the encoded body is not used.
+// It waits for a free Appender of the pool until ctx is done.
+func (t *nativeTransport) Append(ctx context.Context, batch *AppendBatch) error {
+	if cap(t.appenders) == 0 {
+		return &RequestError{Category: "transport", Err: errors.New("no appender, the append worker count is 0")}
+	}
+	var appender *client.Appender
+	select {
+	case appender = <-t.appenders:
+	case <-ctx.Done():
+		return transportError(ctx.Err())
+	}
+	defer func() { t.appenders <- appender }()
+	values := make([]any, len(t.columnTypes))
+	for _, rec := range batch.Records {
+		for i, v := range rec {
+			if ts, ok := v.(int64); ok && t.columnTypes[i] == "timestamp" {
+				v = time.Unix(0, ts)
+			}
+			values[i] = v
+		}
+		if err := appender.Append(values...); err != nil {
+			return nativeError(err)
+		}
+	}
+	return nil
+}
+
+// Select returns the client measured elapsed time until the last row,
+// there is no server side elapse over database/sql.
+func (t *nativeTransport) Select(ctx context.Context, sqlText string) (int64, time.Duration, error) {
+	start := time.Now()
+	rows, err := t.db.QueryContext(ctx, sqlText)
+	if err != nil {
+		return 0, 0, nativeError(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, 0, nativeError(err)
+	}
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	var count int64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, 0, &RequestError{Category: "read", Err: err}
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, nativeError(err)
+	}
+	return count, time.Since(start), nil
+}
+
+func (t *nativeTransport) Close() error {
+	close(t.appenders)
+	for appender := range t.appenders {
+		appender.Close()
+	}
+	return t.db.Close()
+}
+
+// nativeError classifies the errors of neo-client like the http errors,
+// network errors by transportError, the others by the message.
+func nativeError(err error) *RequestError {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return transportError(err)
+	}
+	return reasonError(err.Error())
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VerifyTarget locates the appended records in the table for the
+// read-after-write verification, it is derived from the first tag,
+// timestamp and numeric columns of the append spec.
+type VerifyTarget struct {
+	Table       string
+	TagColumn   string
+	TimeColumn  string
+	ValueColumn string // empty if the records have no numeric column
+
+	tagIdx   int
+	timeIdx  int
+	valueIdx int
+
+	sharedErr error // the records have keys that other requests write too
+}
+
+// dupCall matches the dup generator of a timestamp offset.
+var dupCall = regexp.MustCompile(`\bdup\s*\(`)
+
+func (spec AppendSpec) verifyTarget() (*VerifyTarget, error) {
+	table, err := appendTable(spec.Uri)
+	if err != nil {
+		return nil, err
+	}
+	vt := &VerifyTarget{Table: table, tagIdx: -1, timeIdx: -1, valueIdx: -1}
+	for i, c := range spec.Columns {
+		switch {
+		case c.Type == "tag" && vt.tagIdx < 0:
+			vt.TagColumn, vt.tagIdx = c.Name, i
+		case c.Type == "timestamp" && vt.timeIdx < 0:
+			vt.TimeColumn, vt.timeIdx = c.Name, i
+			if dupCall.MatchString(c.Offset) {
+				// truncated timestamps of the tags of other requests
+				vt.sharedErr = fmt.Errorf("the offset %q of %s writes the same tag and time from other requests", c.Offset, c.Name)
+			}
+		case (c.Type == "int" || c.Type == "random" || c.Type == "gaussian") && vt.valueIdx < 0:
+			vt.ValueColumn, vt.valueIdx = c.Name, i
+		}
+	}
+	if vt.tagIdx < 0 || vt.timeIdx < 0 {
+		return nil, fmt.Errorf("no tag or timestamp column")
+	}
+	if vt.TagColumn == "" || vt.TimeColumn == "" || (vt.valueIdx >= 0 && vt.ValueColumn == "") {
+		return nil, fmt.Errorf("tag, timestamp and value columns should have names")
+	}
+	return vt, nil
+}
+
+type verifyKey struct {
+	tag  string
+	time int64
+}
+
+// verifyRange is the fingerprint of the records of an append request,
+// the expected values of every tag and timestamp in the time range.
+type verifyRange struct {
+	neoHttpAddr string // the endpoint the records were appended to
+	acked       time.Time
+	tags        []string
+	from        int64
+	to          int64
+	count       int64
+	values      map[verifyKey][]float64
+
+	interval time.Duration // backoff of the next poll
+	last     *VerifySample // the outcome of the last poll
+}
+
+// newVerifyRange takes the fingerprint of the records generated by the append spec.
+func (vt *VerifyTarget) newVerifyRange(records [][]any, acked time.Time) (*verifyRange, error) {
+	vr := &verifyRange{acked: acked, from: math.MaxInt64, to: math.MinInt64, values: map[verifyKey][]float64{}}
+	for _, rec := range records {
+		if len(rec) <= max(vt.tagIdx, vt.timeIdx, vt.valueIdx) {
+			return nil, fmt.Errorf("invalid record %v", rec)
+		}
+		tag, ok1 := rec[vt.tagIdx].(string)
+		ts, ok2 := rec[vt.timeIdx].(int64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid record %v", rec)
+		}
+		var value float64
+		if vt.valueIdx >= 0 {
+			switch v := rec[vt.valueIdx].(type) {
+			case float64:
+				value = v
+			case int64:
+				value = float64(v)
+			default:
+				return nil, fmt.Errorf("invalid record %v", rec)
+			}
+		}
+		key := verifyKey{tag: tag, time: ts}
+		if _, exists := vr.values[key]; !exists && !slices.Contains(vr.tags, key.tag) {
+			vr.tags = append(vr.tags, key.tag)
+		}
+		vr.values[key] = append(vr.values[key], value)
+		vr.from, vr.to = min(vr.from, ts), max(vr.to, ts)
+		vr.count++
+	}
+	return vr, nil
+}
+
+// Verifiable returns why the rows read back can not be told apart from the rows of other requests.
+// The tag and time of a record are the key of its row, every request writes its own keys
+// unless the timestamps are truncated by dup.
+func (vt *VerifyTarget) Verifiable() error {
+	return vt.sharedErr
+}
+
+func (vt *VerifyTarget) sqlText(vr *verifyRange) string {
+	tags := make([]string, len(vr.tags))
+	for i, tag := range vr.tags {
+		tags[i] = "'" + strings.ReplaceAll(tag, "'", "''") + "'"
+	}
+	value := "0"
+	if vt.ValueColumn != "" {
+		value = vt.ValueColumn
+	}
+	return fmt.Sprintf("SELECT %s, %s, %s FROM %s WHERE %s IN (%s) AND %s BETWEEN %d AND %d",
+		vt.TagColumn, vt.TimeColumn, value, vt.Table,
+		vt.TagColumn, strings.Join(tags, ","), vt.TimeColumn, vr.from, vr.to)
+}
+
+// VerifySample is the outcome of the verification of an append request.
+//
+//   - Missing: expected rows not found when the verification gave up
+//   - Duplicate: rows found more times than they were appended
+//   - Corrupt: rows of the tag and time of a record with unexpected values
+//   - Delay: time from the append response until all rows were visible
+//   - Visible: all rows were found before the verify timeout
+//   - Skipped: the request was not verified because the verifiers were behind
+type VerifySample struct {
+	Rows      int64
+	Missing   int64
+	Duplicate int64
+	Corrupt   int64
+	Delay     time.Duration
+	Visible   bool
+	Skipped   bool
+}
+
+// compare counts the differences between the expected and the returned rows.
+// Only the tags and times of the records are compared, the other rows in the range
+// are of other requests writing the same tags, e.g. of zipf, or of the same worker.
+func (vr *verifyRange) compare(got map[verifyKey][]float64) VerifySample {
+	ret := VerifySample{Rows: vr.count}
+	for key, want := range vr.values {
+		have := got[key]
+		if len(have) < len(want) {
+			ret.Missing += int64(len(want) - len(have))
+		} else if len(have) > len(want) {
+			ret.Duplicate += int64(len(have) - len(want))
+		}
+		// match the values regardless of the order of the rows
+		unmatched := slices.Clone(have)
+		for _, w := range want {
+			i := slices.IndexFunc(unmatched, func(h float64) bool { return sameValue(w, h) })
+			if i >= 0 {
+				unmatched = slices.Delete(unmatched, i, i+1)
+			}
+		}
+		// values left over are corrupt unless they are counted as duplicates
+		extra := int64(len(unmatched))
+		if len(have) > len(want) {
+			extra -= int64(len(have) - len(want))
+		}
+		ret.Corrupt += max(extra, 0)
+	}
+	return ret
+}
+
+// sameValue compares with the precision of the generated CSV, "%f".
+func sameValue(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*max(1, math.Abs(a))
+}
+
+const (
+	verifyQueueSize       = 1000
+	verifyPollMinInterval = 20 * time.Millisecond
+	verifyPollMaxInterval = time.Second
+)
+
+// Verifier reads back the appended records and compares them with
+// the fingerprints of the append requests.
+//
+// A range that is not fully visible yet is queued again after a backoff,
+// so that the workers do not wait while polling.
+type Verifier struct {
+	Target  *VerifyTarget
+	Workers int
+	Every   int           // verify one of every Every append requests
+	Timeout time.Duration // how long a range is polled until all rows are visible
+
+	queue   chan *verifyRange
+	seq     atomic.Int64
+	pending sync.WaitGroup // ranges added and not yet reported
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+
+	ctx    context.Context // canceled by Stop after the drain timeout
+	cancel context.CancelFunc
+}
+
+func NewVerifier(target *VerifyTarget, workers int, every int, timeout time.Duration) *Verifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Verifier{
+		Target:  target,
+		Workers: workers,
+		Every:   max(every, 1),
+		Timeout: timeout,
+		queue:   make(chan *verifyRange, verifyQueueSize),
+		closeCh: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start runs the verifier workers until Stop.
+func (v *Verifier) Start(s Scenario, client *http.Client, stat *Stat) {
+	for i := 0; i < v.Workers; i++ {
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+			for {
+				select {
+				case vr := <-v.queue:
+					v.verify(s, client, stat, vr)
+				case <-v.closeCh:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Stop waits until the added ranges are verified or timed out, up to the drain timeout.
+// The queries in flight are canceled then and the ranges are reported as they were last seen.
+func (v *Verifier) Stop(drainTimeout time.Duration) {
+	timer := time.AfterFunc(drainTimeout, v.cancel)
+	v.pending.Wait()
+	timer.Stop()
+	v.cancel()
+	close(v.closeCh)
+	v.wg.Wait()
+}
+
+// Add queues the records of an acknowledged append request to the endpoint,
+// the request is skipped if the queue is full.
+func (v *Verifier) Add(neoHttpAddr string, records [][]any, acked time.Time, stat *Stat) {
+	if (v.seq.Add(1)-1)%int64(v.Every) != 0 {
+		return
+	}
+	vr, err := v.Target.newVerifyRange(records, acked)
+	if err != nil {
+		stat.AddError("verify", &RequestError{Category: "parse", Err: err})
+		return
+	}
+	vr.neoHttpAddr = neoHttpAddr
+	vr.interval = verifyPollMinInterval
+	v.pending.Add(1)
+	select {
+	case v.queue <- vr:
+	default:
+		v.pending.Done()
+		stat.AddVerify(VerifySample{Rows: vr.count, Skipped: true})
+	}
+}
+
+// verify queries the range once, then reports it or queues it again.
+func (v *Verifier) verify(s Scenario, client *http.Client, stat *Stat, vr *verifyRange) {
+	deadline := vr.acked.Add(v.Timeout)
+	got, err := v.query(s, client, vr.neoHttpAddr, v.Target.sqlText(vr))
+	if v.ctx.Err() != nil {
+		// drained, the query was canceled
+		deadline = time.Time{}
+	} else if err != nil {
+		stat.AddError("verify", err)
+	} else {
+		sample := vr.compare(got)
+		if sample.Missing == 0 {
+			sample.Visible = true
+			sample.Delay = time.Since(vr.acked)
+			stat.AddVerify(sample)
+			v.pending.Done()
+			return
+		}
+		vr.last = &sample
+	}
+	if time.Now().After(deadline) {
+		if vr.last != nil {
+			stat.AddVerify(*vr.last)
+		} else {
+			// every query failed, the rows are not verified
+			stat.AddVerify(VerifySample{Rows: vr.count, Skipped: true})
+		}
+		v.pending.Done()
+		return
+	}
+	wait := min(vr.interval, time.Until(deadline)+time.Millisecond)
+	vr.interval = min(vr.interval*2, verifyPollMaxInterval)
+	go func() {
+		// a drain does not wait for the backoff
+		select {
+		case <-time.After(wait):
+		case <-v.ctx.Done():
+		}
+		v.queue <- vr
+	}()
+}
+
+func (v *Verifier) query(s Scenario, client *http.Client, neoHttpAddr string, sqlText string) (map[verifyKey][]float64, error) {
+	rsp, err := s.queryJSON(v.ctx, client, neoHttpAddr, sqlText, url.Values{"timeformat": {"ns"}})
+	if err != nil {
+		return nil, err
+	}
+	got := map[verifyKey][]float64{}
+	for _, row := range rsp.Get("data.rows").Array() {
+		cols := row.Array()
+		if len(cols) < 3 {
+			return nil, &RequestError{Category: "parse", Err: fmt.Errorf("invalid row %s", row.Raw)}
+		}
+		key := verifyKey{tag: cols[0].String(), time: cols[1].Int()}
+		got[key] = append(got[key], cols[2].Float())
+	}
+	return got, nil
+}